@@ -0,0 +1,46 @@
+package log
+
+import "strings"
+
+// 每行都带上同一个标签(例如请求 ID)的日志
+type Tagged struct {
+	logger *Logger
+	prefix string
+}
+
+// 使用全局 logger，tag 为空时和直接调用 Info/Error 等相同
+func With(tag string) *Tagged {
+	return (*Logger)(nil).With(tag)
+}
+
+func (logger *Logger) With(tag string) *Tagged {
+	tagged := &Tagged{logger: logger}
+	if len(tag) != 0 {
+		tagged.prefix = "[" + strings.Replace(tag, "%", "%%", -1) + "] "
+	}
+	return tagged
+}
+
+func (tagged *Tagged) base() *Logger {
+	if tagged.logger == nil {
+		return gLogger
+	}
+	return tagged.logger
+}
+
+// 直接调用 doPrintf，保证输出的文件行号是调用者的
+func (tagged *Tagged) Debug(format string, a ...interface{}) {
+	tagged.base().doPrintf(DebugLevel, PrintDebugLevel, tagged.prefix+format, a...)
+}
+
+func (tagged *Tagged) Info(format string, a ...interface{}) {
+	tagged.base().doPrintf(InfoLevel, PrintInfoLevel, tagged.prefix+format, a...)
+}
+
+func (tagged *Tagged) Error(format string, a ...interface{}) {
+	tagged.base().doPrintf(ErrorLevel, PrintErrorLevel, tagged.prefix+format, a...)
+}
+
+func (tagged *Tagged) Fatal(format string, a ...interface{}) {
+	tagged.base().doPrintf(FatalLevel, PrintFatalLevel, tagged.prefix+format, a...)
+}
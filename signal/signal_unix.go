@@ -4,19 +4,67 @@ package signal
 
 import (
 	"bmob/library/log"
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 )
 
 func AddKillListener(callbacks ...func()) {
 	go addListeners(callbacks)
 }
 
+// 收到退出信号后同时执行所有 drain(例如 web.Shutdown)，等它们结束或超时后退出，
+// 期间再次收到退出信号时立即退出
+//
+//	signal.AddDrainListener(30*time.Second, web.Shutdown)
+func AddDrainListener(timeout time.Duration, drains ...func(ctx context.Context) error) {
+	go func() {
+		sigs := waitExit()
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		go func() {
+			for sig := range sigs {
+				switch sig {
+				case syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT:
+					fmt.Println("[signals()] Interrupt again, exit now")
+					os.Exit(1)
+				}
+			}
+		}()
+
+		wg := sync.WaitGroup{}
+		for _, drain := range drains {
+			wg.Add(1)
+			go func(drain func(ctx context.Context) error) {
+				defer wg.Done()
+				if e := drain(ctx); e != nil {
+					fmt.Println("[signals()] Drain error:", e)
+				}
+			}(drain)
+		}
+		wg.Wait()
+		log.Info("Progress killed by user. All connections have be drained. Exit!")
+		os.Exit(0)
+	}()
+}
+
 func addListeners(callbacks []func()) {
-	sigs := make(chan os.Signal)
-	defer close(sigs)
+	sigs := waitExit()
+	signal.Stop(sigs)
+	for _, cb := range callbacks {
+		cb()
+	}
+	log.Info("Progress killed by user. All concluding works have be done. Exit!")
+	os.Exit(0)
+}
+
+// 阻塞到收到退出信号，返回的 channel 之后仍会收到信号
+func waitExit() chan os.Signal {
+	sigs := make(chan os.Signal, 1)
 
 	signal.Notify(sigs,
 		syscall.SIGQUIT,
@@ -42,10 +90,5 @@ EXIT:
 			}
 		}
 	}
-
-	for _, cb := range callbacks {
-		cb()
-	}
-	log.Info("Progress killed by user. All concluding works have be done. Exit!")
-	os.Exit(0)
+	return sigs
 }
@@ -2,8 +2,16 @@
 
 package signal
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"time"
+)
 
 func AddKillListener(callbacks ...func()) {
 	fmt.Println("Warning! cannot call 'AddKillListener' at windows's platform")
 }
+
+func AddDrainListener(timeout time.Duration, drains ...func(ctx context.Context) error) {
+	fmt.Println("Warning! cannot call 'AddDrainListener' at windows's platform")
+}
@@ -0,0 +1,200 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"zwei.ren/console"
+	"zwei.ren/log"
+)
+
+// 一条访问日志
+type AccessEntry struct {
+	Time      time.Time     `json:"time"`
+	Duration  time.Duration `json:"-"`
+	Status    int           `json:"status"`
+	Bytes     int64         `json:"bytes"`
+	Method    string        `json:"method"`
+	URL       string        `json:"url"`
+	Proto     string        `json:"proto"`
+	Host      string        `json:"host"`
+	RemoteIP  string        `json:"ip"`
+	User      string        `json:"user,omitempty"`
+	Referer   string        `json:"referer,omitempty"`
+	UserAgent string        `json:"user_agent,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+	Route     string        `json:"route,omitempty"` // 路由名，没有时为路由的 pattern
+}
+
+// 把一条访问日志格式化成一行(不含换行)
+type AccessFormat func(entry *AccessEntry) string
+
+// 终端中带颜色的格式
+func FormatPretty(entry *AccessEntry) string {
+	var status, method string
+	if entry.Status < 200 || entry.Status > 399 {
+		status = console.Red(strconv.Itoa(entry.Status))
+	} else {
+		status = console.Magenta(strconv.Itoa(entry.Status))
+	}
+	switch entry.Method {
+	case "POST":
+		method = console.Yellow(entry.Method)
+	case "PUT":
+		method = console.Black(entry.Method)
+	case "DELETE":
+		method = console.Magenta(entry.Method)
+	case "OPTIONS":
+		method = console.Cyan(entry.Method)
+	default:
+		method = console.Blue(entry.Method)
+	}
+	return fmt.Sprintf(
+		"[Zwei.Ren/Web] %s | %3s | %12v | %9s |%21s | %5s | %s",
+		entry.Time.Format("2006-01-02 15:04:05"),
+		status,
+		entry.Duration,
+		formatBytes(entry.Bytes),
+		entry.RemoteIP,
+		method,
+		console.Green(entry.URL),
+	)
+}
+
+// Common Log Format
+func FormatCommon(entry *AccessEntry) string {
+	user, bytes := entry.User, "-"
+	if len(user) == 0 {
+		user = "-"
+	}
+	if entry.Bytes > 0 {
+		bytes = strconv.FormatInt(entry.Bytes, 10)
+	}
+	return fmt.Sprintf(
+		"%s - %s [%s] %s %d %s",
+		entry.RemoteIP,
+		user,
+		entry.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(entry.Method+" "+entry.URL+" "+entry.Proto),
+		entry.Status,
+		bytes,
+	)
+}
+
+// Combined Log Format，在 Common 之后加上 Referer 和 User-Agent
+func FormatCombined(entry *AccessEntry) string {
+	return FormatCommon(entry) + " " + strconv.Quote(entry.Referer) + " " + strconv.Quote(entry.UserAgent)
+}
+
+// 一行一个 json，耗时为毫秒
+func FormatJSON(entry *AccessEntry) string {
+	bs, _ := json.Marshal(struct {
+		*AccessEntry
+		Latency float64 `json:"latency_ms"`
+	}{entry, float64(entry.Duration) / float64(time.Millisecond)})
+	return string(bs)
+}
+
+// HttpServer 的访问日志配置
+type AccessLog struct {
+	// 为nil时使用 FormatPretty
+	Format AccessFormat
+	// 为nil时直接输出到标准输出，否则以 Info 级别写入
+	Logger *log.Logger
+	// 记录的比例，0 或 >=1 时全部记录，5xx 错误总是记录
+	SampleRate float64
+	// 不记录的路径，以 * 结尾时按前缀匹配，例如 "/health"、"/static/*"
+	Exclude []string
+	// 返回 false 时不记录，在 Exclude 和 SampleRate 之后判断
+	Filter func(entry *AccessEntry) bool
+}
+
+var defaultAccessLog = &AccessLog{}
+
+func (this *AccessLog) excluded(urlPath string) bool {
+	for _, pattern := range this.Exclude {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(urlPath, pattern[:len(pattern)-1]) {
+				return true
+			}
+		} else if urlPath == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+func (this *AccessLog) sampled(status int) bool {
+	if this.SampleRate <= 0 || this.SampleRate >= 1 || status >= 500 {
+		return true
+	}
+	return rand.Float64() < this.SampleRate
+}
+
+func (this *AccessLog) write(entry *AccessEntry) {
+	format := this.Format
+	if format == nil {
+		format = FormatPretty
+	}
+	if this.Logger != nil {
+		this.Logger.Info("%s", format(entry))
+	} else {
+		fmt.Println(format(entry))
+	}
+}
+
+// 开启了 IsLog 或设置了 AccessLog 时，请求结束后记录
+func (this *HttpServer) log(
+	status_ptr *int, from time.Time,
+	request *http.Request,
+	writer *responseWriter,
+	handler *IHandler,
+	route **_Router,
+) {
+	accessLog := this.AccessLog
+	if accessLog == nil {
+		accessLog = defaultAccessLog
+	}
+	status := writer.Status(*status_ptr)
+	if accessLog.excluded(request.URL.Path) || !accessLog.sampled(status) {
+		return
+	}
+
+	now := time.Now()
+	entry := &AccessEntry{
+		Time:      now,
+		Duration:  now.Sub(from),
+		Status:    status,
+		Bytes:     writer.size,
+		Method:    request.Method,
+		URL:       request.URL.String(),
+		Proto:     request.Proto,
+		Host:      request.Host,
+		Referer:   request.Referer(),
+		UserAgent: request.UserAgent(),
+	}
+	if handler != nil && *handler != nil {
+		entry.RemoteIP = (*handler).IP()
+	}
+	if len(entry.RemoteIP) == 0 {
+		entry.RemoteIP = request.RemoteAddr
+	}
+	if user, _, ok := request.BasicAuth(); ok {
+		entry.User = user
+	}
+	if entry.RequestID = RequestIDFrom(request.Context()); len(entry.RequestID) == 0 && len(RequestIDHeader) != 0 {
+		entry.RequestID = request.Header.Get(RequestIDHeader)
+	}
+	if route != nil && *route != nil {
+		entry.Route = routeLabel(*route)
+	}
+	if accessLog.Filter != nil && !accessLog.Filter(entry) {
+		return
+	}
+	accessLog.write(entry)
+}
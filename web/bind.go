@@ -0,0 +1,476 @@
+package web
+
+import (
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	Err_BindTarget = errors.New("Bind target must be a pointer to struct")
+
+	emailPattern = regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+
+	timeType            = reflect.TypeOf(time.Time{})
+	durationType        = reflect.TypeOf(time.Duration(0))
+	fileType            = reflect.TypeOf((*MultipartFileData)(nil))
+	fileArrType         = reflect.TypeOf([]*MultipartFileData(nil))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+	// 按顺序填充，后面的覆盖前面的
+	bindSources = []string{"form", "query", "path"}
+)
+
+// 单个字段的绑定或校验错误
+type FieldError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+func (this *FieldError) Error() string {
+	return this.Field + " " + this.Message
+}
+
+type FieldErrors []*FieldError
+
+func (this FieldErrors) Error() string {
+	msgs := make([]string, len(this))
+	for i, e := range this {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// 把请求参数绑定到 struct 上，然后按 validate 标签校验
+//
+//	type Query struct {
+//		ID   int      `path:"id"`
+//		Page int      `query:"page" validate:"min=1"`
+//		Name string   `form:"name" json:"name" validate:"required"`
+//		Tags []string `query:"tag"`
+//	}
+//
+// validate 的字段是可选的，没有 required 时空值(包括数字 0)不检查其它规则，
+// 上面的 page=0 或者没有 page 都能通过，需要时写成 validate:"required,min=1"
+//
+// json/xml 的请求体直接 Unmarshal，其它来源按 form/query/path 标签填充
+// 类型转换或校验失败时返回 FieldErrors，validate 标签写错时返回普通的 error
+func (this *Handler) Bind(dst interface{}) (e error) {
+	rv := reflect.ValueOf(dst)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return Err_BindTarget
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(this.GetHeader("content-type"))
+	switch mediaType {
+	case "application/json":
+		if body := this.GetBody(); len(body) != 0 {
+			if e = json.Unmarshal(body, dst); e != nil {
+				return
+			}
+		}
+	case "application/xml", "text/xml":
+		if body := this.GetBody(); len(body) != 0 {
+			if e = xml.Unmarshal(body, dst); e != nil {
+				return
+			}
+		}
+	}
+
+	pathValues := url.Values{}
+	for k, v := range this.pathParams {
+		pathValues.Set(k, v)
+	}
+	sources := map[string]url.Values{
+		"form":  this.bindFormValues(mediaType),
+		"query": this.GetQueryValues(),
+		"path":  pathValues,
+	}
+
+	if _, e = rulesOf(rv.Elem().Type()); e != nil {
+		return
+	}
+	errs := FieldErrors{}
+	bindStruct(rv.Elem(), sources, this.fileArrParams, &errs)
+	if len(errs) == 0 {
+		if e = validateStruct(rv.Elem(), "", &errs); e != nil {
+			return
+		}
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+func (this *Handler) bindFormValues(mediaType string) url.Values {
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		return this.GetFormValues()
+	}
+	return url.Values{}
+}
+
+// 按顺序校验 struct 的 validate 标签
+func Validate(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return Err_BindTarget
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return Err_BindTarget
+	}
+	errs := FieldErrors{}
+	if e := validateStruct(rv, "", &errs); e != nil {
+		return e
+	}
+	if len(errs) != 0 {
+		return errs
+	}
+	return nil
+}
+
+func tagName(sf reflect.StructField, tag string) string {
+	name := sf.Tag.Get(tag)
+	if ind := strings.Index(name, ","); ind != -1 {
+		name = name[:ind]
+	}
+	if name == "-" {
+		return ""
+	}
+	return name
+}
+
+// 错误里显示的字段名
+func fieldName(sf reflect.StructField) string {
+	for _, tag := range []string{"json", "form", "query", "path", "xml"} {
+		if name := tagName(sf, tag); len(name) != 0 {
+			return name
+		}
+	}
+	return sf.Name
+}
+
+func isNestedStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType && !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+func bindStruct(v reflect.Value, sources map[string]url.Values, files map[string][]*MultipartFileData, errs *FieldErrors) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf, fv := t.Field(i), v.Field(i)
+		if len(sf.PkgPath) != 0 && !sf.Anonymous {
+			continue
+		}
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			bindStruct(fv, sources, files, errs)
+			continue
+		}
+		tagged := false
+		for _, source := range bindSources {
+			name := tagName(sf, source)
+			if len(name) == 0 {
+				continue
+			}
+			tagged = true
+			if source == "form" && (sf.Type == fileType || sf.Type == fileArrType) {
+				if fs := files[name]; len(fs) != 0 {
+					if sf.Type == fileType {
+						fv.Set(reflect.ValueOf(fs[0]))
+					} else {
+						fv.Set(reflect.ValueOf(fs))
+					}
+				}
+				continue
+			}
+			if vs, exists := sources[source][name]; exists {
+				if e := setField(fv, vs, sf.Tag.Get("time_format")); e != nil {
+					*errs = append(*errs, &FieldError{Field: name, Tag: "type", Message: e.Error()})
+				}
+			}
+		}
+		if !tagged && sf.Type.Kind() == reflect.Struct && isNestedStruct(sf.Type) {
+			bindStruct(fv, sources, files, errs)
+		}
+	}
+}
+
+func setField(fv reflect.Value, vs []string, layout string) error {
+	if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(fv.Type(), len(vs), len(vs))
+		for i, s := range vs {
+			if e := setValue(slice.Index(i), s, layout); e != nil {
+				return e
+			}
+		}
+		fv.Set(slice)
+		return nil
+	}
+	if len(vs) == 0 {
+		return nil
+	}
+	return setValue(fv, vs[0], layout)
+}
+
+// 把字符串转成 fv 的类型
+func setValue(fv reflect.Value, s string, layout string) (e error) {
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return setValue(fv.Elem(), s, layout)
+	}
+	if fv.CanAddr() && fv.Addr().Type().Implements(textUnmarshalerType) && fv.Type() != timeType {
+		return fv.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+	switch fv.Type() {
+	case timeType:
+		if len(layout) == 0 {
+			layout = time.RFC3339
+		}
+		var t time.Time
+		if t, e = time.Parse(layout, s); e == nil {
+			fv.Set(reflect.ValueOf(t))
+		}
+		return
+	case durationType:
+		var d time.Duration
+		if d, e = time.ParseDuration(s); e == nil {
+			fv.SetInt(int64(d))
+		}
+		return
+	}
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(s)
+	case reflect.Bool:
+		var b bool
+		if b, e = strconv.ParseBool(s); e == nil {
+			fv.SetBool(b)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		if i, e = strconv.ParseInt(s, 10, fv.Type().Bits()); e == nil {
+			fv.SetInt(i)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var u uint64
+		if u, e = strconv.ParseUint(s, 10, fv.Type().Bits()); e == nil {
+			fv.SetUint(u)
+		}
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		if f, e = strconv.ParseFloat(s, fv.Type().Bits()); e == nil {
+			fv.SetFloat(f)
+		}
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() == reflect.Uint8 {
+			fv.SetBytes([]byte(s))
+		} else {
+			e = errors.New("unsupported type " + fv.Type().String())
+		}
+	default:
+		e = errors.New("unsupported type " + fv.Type().String())
+	}
+	if e != nil {
+		if numErr, is := e.(*strconv.NumError); is {
+			e = errors.New("cannot parse '" + s + "' as " + fv.Type().String() + ": " + numErr.Err.Error())
+		}
+	}
+	return
+}
+
+type validateRule struct {
+	tag, param string
+	limit      float64
+}
+
+// struct 类型 => 每个字段解析好的 validate 规则，第一次校验时解析
+var rulesCache sync.Map
+
+type structRules struct {
+	fields [][]validateRule
+	err    error
+}
+
+func parseRules(rules string) (parsed []validateRule, e error) {
+	for _, rule := range strings.Split(rules, ",") {
+		r := validateRule{tag: rule}
+		if ind := strings.Index(rule, "="); ind != -1 {
+			r.tag, r.param = rule[:ind], rule[ind+1:]
+		}
+		switch r.tag {
+		case "", "required", "email", "url", "oneof":
+		case "min", "max", "len":
+			if r.limit, e = strconv.ParseFloat(r.param, 64); e != nil {
+				return nil, errors.New("Wrong validate rule: " + rule)
+			}
+		default:
+			return nil, errors.New("Unknown validate rule: " + rule)
+		}
+		parsed = append(parsed, r)
+	}
+	return
+}
+
+func rulesOf(t reflect.Type) ([][]validateRule, error) {
+	if cached, has := rulesCache.Load(t); has {
+		return cached.(*structRules).fields, cached.(*structRules).err
+	}
+	res := &structRules{fields: make([][]validateRule, t.NumField())}
+	for i := 0; i < t.NumField() && res.err == nil; i++ {
+		if rules := t.Field(i).Tag.Get("validate"); len(rules) != 0 && rules != "-" {
+			if res.fields[i], res.err = parseRules(rules); res.err != nil {
+				res.err = errors.New(t.String() + "." + t.Field(i).Name + ": " + res.err.Error())
+			}
+		}
+	}
+	rulesCache.Store(t, res)
+	return res.fields, res.err
+}
+
+// 规则写错时返回 error，校验不通过的字段加到 errs
+func validateStruct(v reflect.Value, prefix string, errs *FieldErrors) error {
+	t := v.Type()
+	rules, e := rulesOf(t)
+	if e != nil {
+		return e
+	}
+	for i := 0; i < t.NumField(); i++ {
+		sf, fv := t.Field(i), v.Field(i)
+		if len(sf.PkgPath) != 0 && !sf.Anonymous {
+			continue
+		}
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			if e = validateStruct(fv, prefix, errs); e != nil {
+				return e
+			}
+			continue
+		}
+		name := prefix + fieldName(sf)
+		if len(rules[i]) != 0 {
+			if fe := validateField(fv, rules[i]); fe != nil {
+				fe.Field = name
+				*errs = append(*errs, fe)
+				continue
+			}
+		}
+		if isNestedStruct(sf.Type) {
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if e = validateStruct(fv, name+".", errs); e != nil {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func isEmptyValue(fv reflect.Value) bool {
+	switch fv.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Array, reflect.String:
+		return fv.Len() == 0
+	}
+	return fv.IsZero()
+}
+
+// 按顺序执行 required,min=1,max=10,len=6,email,url,oneof=a b
+// 没有 required 时空值(空字符串、数字 0、nil)跳过其它规则
+func validateField(fv reflect.Value, rules []validateRule) *FieldError {
+	if isEmptyValue(fv) {
+		for _, rule := range rules {
+			if rule.tag == "required" {
+				return &FieldError{Tag: "required", Message: "is required"}
+			}
+		}
+		return nil
+	}
+	for fv.Kind() == reflect.Ptr {
+		fv = fv.Elem()
+	}
+	for _, rule := range rules {
+		tag, param := rule.tag, rule.param
+		switch tag {
+		case "min", "max", "len":
+			n, isLen := measure(fv)
+			var msg string
+			switch {
+			case tag == "min" && n < rule.limit:
+				msg = "must be at least " + param
+			case tag == "max" && n > rule.limit:
+				msg = "must be at most " + param
+			case tag == "len" && n != rule.limit:
+				msg = "must be exactly " + param
+			}
+			if len(msg) != 0 {
+				if isLen {
+					msg += " in length"
+				}
+				return &FieldError{Tag: tag, Param: param, Message: msg}
+			}
+		case "email":
+			if fv.Kind() != reflect.String || !emailPattern.MatchString(fv.String()) {
+				return &FieldError{Tag: tag, Message: "must be a valid email address"}
+			}
+		case "url":
+			if fv.Kind() != reflect.String {
+				return &FieldError{Tag: tag, Message: "must be a valid URL"}
+			}
+			if u, e := url.ParseRequestURI(fv.String()); e != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+				return &FieldError{Tag: tag, Message: "must be a valid URL"}
+			}
+		case "oneof":
+			value, found := fmt.Sprintf("%v", fv.Interface()), false
+			for _, option := range strings.Fields(param) {
+				if option == value {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return &FieldError{Tag: tag, Param: param, Message: "must be one of [" + param + "]"}
+			}
+		}
+	}
+	return nil
+}
+
+// 数字返回值本身，字符串和数组返回长度
+func measure(fv reflect.Value) (n float64, isLen bool) {
+	switch fv.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(fv.String())), true
+	case reflect.Slice, reflect.Map, reflect.Array:
+		return float64(fv.Len()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(fv.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(fv.Uint()), false
+	case reflect.Float32, reflect.Float64:
+		return fv.Float(), false
+	}
+	return 0, false
+}
@@ -0,0 +1,131 @@
+package web
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+type validateTarget struct {
+	Name  string   `json:"name" validate:"required,min=2,max=5"`
+	Age   int      `json:"age" validate:"min=18,max=60"`
+	Count int      `json:"count" validate:"required,min=1"`
+	Code  string   `json:"code" validate:"len=4"`
+	Tags  []string `json:"tags" validate:"max=2"`
+	Role  string   `json:"role" validate:"oneof=admin user"`
+	Email string   `json:"email" validate:"email"`
+	Home  string   `json:"home" validate:"url"`
+}
+
+func validTarget() validateTarget {
+	return validateTarget{Name: "bob", Count: 1}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		edit  func(v *validateTarget)
+		field string
+		tag   string
+	}{
+		{"valid", func(v *validateTarget) {}, "", ""},
+		{"required string", func(v *validateTarget) { v.Name = "" }, "name", "required"},
+		{"required number", func(v *validateTarget) { v.Count = 0 }, "count", "required"},
+		{"min string", func(v *validateTarget) { v.Name = "b" }, "name", "min"},
+		{"max string", func(v *validateTarget) { v.Name = "robert" }, "name", "max"},
+		{"max counts runes", func(v *validateTarget) { v.Name = "中文名字" }, "", ""},
+		{"optional zero skips min", func(v *validateTarget) { v.Age = 0 }, "", ""},
+		{"min number", func(v *validateTarget) { v.Age = 17 }, "age", "min"},
+		{"max number", func(v *validateTarget) { v.Age = 61 }, "age", "max"},
+		{"number in range", func(v *validateTarget) { v.Age = 18 }, "", ""},
+		{"len", func(v *validateTarget) { v.Code = "123" }, "code", "len"},
+		{"len ok", func(v *validateTarget) { v.Code = "1234" }, "", ""},
+		{"max slice", func(v *validateTarget) { v.Tags = []string{"a", "b", "c"} }, "tags", "max"},
+		{"oneof", func(v *validateTarget) { v.Role = "root" }, "role", "oneof"},
+		{"oneof ok", func(v *validateTarget) { v.Role = "user" }, "", ""},
+		{"email", func(v *validateTarget) { v.Email = "bob" }, "email", "email"},
+		{"email ok", func(v *validateTarget) { v.Email = "bob@example.com" }, "", ""},
+		{"url", func(v *validateTarget) { v.Home = "example.com" }, "home", "url"},
+		{"url ok", func(v *validateTarget) { v.Home = "https://example.com/a" }, "", ""},
+	}
+	for _, c := range cases {
+		v := validTarget()
+		c.edit(&v)
+		e := Validate(&v)
+		if len(c.field) == 0 {
+			if e != nil {
+				t.Errorf("%s: unexpected error %v", c.name, e)
+			}
+			continue
+		}
+		errs, is := e.(FieldErrors)
+		if !is || len(errs) != 1 || errs[0].Field != c.field || errs[0].Tag != c.tag {
+			t.Errorf("%s: expect %s/%s, got %v", c.name, c.field, c.tag, e)
+		}
+	}
+}
+
+func TestValidateBadRule(t *testing.T) {
+	cases := []interface{}{
+		&struct {
+			A int `validate:"between=1"`
+		}{},
+		&struct {
+			A int `validate:"min=one"`
+		}{},
+	}
+	for _, v := range cases {
+		e := Validate(v)
+		if _, is := e.(FieldErrors); e == nil || is {
+			t.Errorf("%T: expect a rule error, got %v", v, e)
+		}
+	}
+}
+
+type bindHandler struct {
+	Handler
+}
+
+func (this *bindHandler) Handle() {
+	var query struct {
+		ID   int      `path:"id"`
+		Page int      `query:"page" validate:"min=1"`
+		Name string   `form:"name" validate:"required"`
+		Tags []string `query:"tag"`
+	}
+	if e := this.Bind(&query); e != nil {
+		this.ResponseStatus(400)
+		this.ResponseData(e.Error())
+		return
+	}
+	this.ResponseOK()
+	this.ResponseData(query)
+}
+
+func TestBind(t *testing.T) {
+	server := &HttpServer{}
+	server.AddRouter("/items/:id", func() IHandler { return new(bindHandler) })
+	cases := []struct {
+		query, form string
+		status      int
+		body        string
+	}{
+		{"page=2&tag=a&tag=b", "name=bob", 200, `{"ID":7,"Page":2,"Name":"bob","Tags":["a","b"]}`},
+		{"", "name=bob", 200, `"Page":0`},
+		{"page=0", "name=bob", 200, `"Page":0`},
+		{"page=-1", "name=bob", 400, "page must be at least 1"},
+		{"page=x", "name=bob", 400, "page"},
+		{"page=1", "", 400, "name is required"},
+	}
+	for _, c := range cases {
+		request := httptest.NewRequest("POST", "/items/7?"+c.query, strings.NewReader(c.form))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.Header.Set("Accept", "application/json")
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, request)
+		if rec.Code != c.status || !strings.Contains(rec.Body.String(), c.body) {
+			t.Errorf("%s %s: %d %s", c.query, url.QueryEscape(c.form), rec.Code, rec.Body.String())
+		}
+	}
+}
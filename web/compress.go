@@ -0,0 +1,360 @@
+package web
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"container/list"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"zwei.ren/log"
+)
+
+// 创建压缩输出，返回的 writer 在 Close() 时写出剩余的数据
+type Compressor func(w io.Writer) (io.WriteCloser, error)
+
+var (
+	// 内置 gzip/deflate 的压缩级别，动态响应每次都要压缩，默认不用最高级别
+	CompressionLevel = flate.DefaultCompression
+
+	compressLock sync.RWMutex
+	compressors  = map[string]Compressor{
+		"gzip": func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, CompressionLevel)
+		},
+		"deflate": func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, CompressionLevel)
+		},
+	}
+	// q 值相同时服务端的偏好顺序
+	encodingOrder = []string{"br", "zstd", "gzip", "deflate"}
+	// 预先压缩好的文件后缀，例如 app.js.br
+	encodingExts = map[string]string{"br": ".br", "zstd": ".zst", "gzip": ".gz"}
+)
+
+// 注册压缩算法，标准库没有 br 和 zstd，需要的话自行注册，例如：
+//
+//	web.RegisterCompressor("br", func(w io.Writer) (io.WriteCloser, error) {
+//		return brotli.NewWriterLevel(w, brotli.DefaultCompression), nil
+//	})
+//
+// 没有注册时仍然会使用预先压缩好的 .br/.zst 文件
+func RegisterCompressor(encoding string, fn Compressor) {
+	compressLock.Lock()
+	defer compressLock.Unlock()
+	encoding = strings.ToLower(encoding)
+	compressors[encoding] = fn
+	for _, enc := range encodingOrder {
+		if enc == encoding {
+			return
+		}
+	}
+	encodingOrder = append(encodingOrder, encoding)
+}
+
+func getCompressor(encoding string) Compressor {
+	compressLock.RLock()
+	defer compressLock.RUnlock()
+	return compressors[encoding]
+}
+
+func compress(encoding string, bs []byte) ([]byte, error) {
+	compressor := getCompressor(encoding)
+	if compressor == nil {
+		return nil, nil
+	}
+	buff := bytes.NewBuffer([]byte{})
+	writer, e := compressor(buff)
+	if e != nil {
+		return nil, e
+	}
+	if _, e = writer.Write(bs); e == nil {
+		e = writer.Close()
+	}
+	return buff.Bytes(), e
+}
+
+// 按 q 值从高到低返回客户端接受的编码，不含 identity，q=0 的不返回
+func parseAcceptEncoding(header string) []string {
+	qs := map[string]float64{}
+	wildcard := -1.0
+	for _, item := range strings.Split(header, ",") {
+		fields := strings.Split(item, ";")
+		encoding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(encoding) == 0 {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if f, e := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); e == nil {
+					q = f
+				}
+			}
+		}
+		if encoding == "*" {
+			wildcard = q
+		} else if encoding == "x-gzip" {
+			qs["gzip"] = q
+		} else {
+			qs[encoding] = q
+		}
+	}
+
+	compressLock.RLock()
+	order := append([]string{}, encodingOrder...)
+	compressLock.RUnlock()
+
+	encodings := []string{}
+	weights := map[string]float64{}
+	for _, enc := range order {
+		q, has := qs[enc]
+		if !has {
+			q = wildcard
+		}
+		if q > 0 {
+			encodings = append(encodings, enc)
+			weights[enc] = q
+		}
+	}
+	sort.SliceStable(encodings, func(i, j int) bool {
+		return weights[encodings[i]] > weights[encodings[j]]
+	})
+	return encodings
+}
+
+var (
+	// 静态文件压缩结果缓存的内存上限，<1 时不缓存
+	StaticCacheSize int64 = 1024 * 1024 * 32 // 32m
+
+	staticCache = &assetCache{items: map[string]*list.Element{}, lru: list.New()}
+)
+
+type assetEntry struct {
+	key     string
+	modTime time.Time
+	size    int64
+	data    []byte // nil 表示压缩后没有变小，直接返回原文件
+}
+
+// 按 路径+编码 保存压缩结果，文件的修改时间或大小变化后失效，超出 StaticCacheSize 时淘汰最久没用的
+type assetCache struct {
+	lock  sync.Mutex
+	items map[string]*list.Element
+	lru   *list.List
+	used  int64
+}
+
+func (this *assetCache) get(key string, modTime time.Time, size int64) (entry *assetEntry, ok bool) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if elem := this.items[key]; elem != nil {
+		entry = elem.Value.(*assetEntry)
+		if entry.modTime.Equal(modTime) && entry.size == size {
+			this.lru.MoveToFront(elem)
+			return entry, true
+		}
+		this.remove(elem)
+	}
+	return nil, false
+}
+
+func (this *assetCache) set(entry *assetEntry) {
+	cost := int64(len(entry.data) + len(entry.key))
+	if cost > StaticCacheSize {
+		return
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if elem := this.items[entry.key]; elem != nil {
+		this.remove(elem)
+	}
+	this.items[entry.key] = this.lru.PushFront(entry)
+	this.used += cost
+	for this.used > StaticCacheSize {
+		this.remove(this.lru.Back())
+	}
+}
+
+func (this *assetCache) remove(elem *list.Element) {
+	entry := this.lru.Remove(elem).(*assetEntry)
+	delete(this.items, entry.key)
+	this.used -= int64(len(entry.data) + len(entry.key))
+}
+
+// 每种编码的内容不同，ETag 加上编码的后缀，例如 "5f0c-1a2b-gzip"
+func (this *staticRouter) setEncoding(encoding string) {
+	this.ResHeaders["Content-Encoding"] = []string{encoding}
+	if etags := this.ResHeaders["ETag"]; len(etags) != 0 && strings.HasSuffix(etags[0], `"`) {
+		this.ResHeaders["ETag"] = []string{strings.TrimSuffix(etags[0], `"`) + "-" + encoding + `"`}
+	}
+}
+
+// If-None-Match 是原始的或者加了编码后缀的 ETag 时都算命中
+func matchETag(ifNoneMatch, etag string) bool {
+	base := strings.TrimSuffix(etag, `"`)
+	for _, tag := range strings.Split(ifNoneMatch, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+		if strings.HasPrefix(tag, base+"-") && strings.HasSuffix(tag, `"`) {
+			encoding := tag[len(base)+1 : len(tag)-1]
+			if len(encodingExts[encoding]) != 0 || getCompressor(encoding) != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 按 Accept-Encoding 返回预先压缩好的文件或者压缩后的内容，没有合适的编码时返回 false
+func (this *staticRouter) responseEncoded(rel, filePath string, info os.FileInfo) bool {
+	for _, encoding := range parseAcceptEncoding(this.GetHeader("accept-encoding")) {
+		if ext := encodingExts[encoding]; len(ext) != 0 {
+			siblingPath, _ := SafeJoin(this.Folder, rel+ext, !this.DenyHidden)
+			if sibling, e := os.Stat(siblingPath); len(siblingPath) != 0 && e == nil && sibling.Mode().IsRegular() && !sibling.ModTime().Before(info.ModTime()) {
+				if file, e := os.Open(siblingPath); e == nil {
+					this.setEncoding(encoding)
+					this.ResHeaders["Content-Length"] = []string{strconv.FormatInt(sibling.Size(), 10)}
+					this.ResponseData(&Stream{Reader: file})
+					this.ResponseOK()
+					return true
+				}
+			}
+		}
+		if info.Size() > MaxStaticFileSize || getCompressor(encoding) == nil {
+			continue
+		}
+
+		key := filePath + "|" + encoding
+		useCache := this.OpenCache && StaticCacheSize > 0
+		var entry *assetEntry
+		var ok bool
+		if useCache {
+			entry, ok = staticCache.get(key, info.ModTime(), info.Size())
+		}
+		if !ok {
+			bs, e := ioutil.ReadFile(filePath)
+			var compressed []byte
+			if e == nil {
+				compressed, e = compress(encoding, bs)
+			}
+			if e != nil {
+				log.Error("Compress static file %s with %s failed: %v", filePath, encoding, e)
+				continue
+			}
+			entry = &assetEntry{key: key, modTime: info.ModTime(), size: info.Size()}
+			if len(compressed) < len(bs) {
+				entry.data = compressed
+			}
+			if useCache {
+				staticCache.set(entry)
+			}
+		}
+		if entry.data != nil {
+			this.setEncoding(encoding)
+			this.ResHeaders["Content-Length"] = []string{strconv.Itoa(len(entry.data))}
+			this.ResponseData(entry.data)
+			this.ResponseOK()
+			return true
+		}
+	}
+	return false
+}
+
+// 默认压缩的 Content-Type
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"application/x-msgpack",
+	"image/svg+xml",
+}
+
+// 动态响应的压缩配置，设置到 HttpServer.Compression 上生效
+//
+//	web.DefaultServer.Compression = &web.Compression{MinSize: 1024}
+type Compression struct {
+	MinSize      int      // 小于这个大小的响应不压缩，web.Stream 没有 Content-Length 时总是压缩
+	ContentTypes []string // 允许压缩的类型，可以是 text/* 这种，为空时使用 DefaultCompressTypes
+}
+
+func EnableCompression(minSize int, contentTypes ...string) {
+	DefaultServer.Compression = &Compression{MinSize: minSize, ContentTypes: contentTypes}
+}
+
+func (this *Compression) allowType(contentType string) bool {
+	types := this.ContentTypes
+	if len(types) == 0 {
+		types = DefaultCompressTypes
+	}
+	contentType = strings.ToLower(contentType)
+	for _, t := range types {
+		if mediaTypeMatch(strings.ToLower(t), contentType) {
+			return true
+		}
+	}
+	return false
+}
+
+// 需要压缩时设置响应头并返回压缩的 writer，size 为 -1 表示大小未知
+func (this *Compression) wrap(writer http.ResponseWriter, request *http.Request, status, size int) io.WriteCloser {
+	header := writer.Header()
+	switch {
+	case status < 200, status == http.StatusNoContent, status == http.StatusNotModified, status == http.StatusPartialContent:
+		return nil
+	case len(header.Get("Content-Encoding")) != 0, len(header.Get("Content-Range")) != 0:
+		return nil
+	case !this.allowType(header.Get("Content-Type")):
+		return nil
+	}
+	addVary(header, "Accept-Encoding")
+
+	if size < 0 {
+		if length, e := strconv.Atoi(header.Get("Content-Length")); e == nil {
+			size = length
+		}
+	}
+	if size >= 0 && size < this.MinSize {
+		return nil
+	}
+	for _, encoding := range parseAcceptEncoding(request.Header.Get("Accept-Encoding")) {
+		if compressor := getCompressor(encoding); compressor != nil {
+			cw, e := compressor(writer)
+			if e != nil {
+				log.Error("Create %s compressor failed: %v", encoding, e)
+				return nil
+			}
+			header.Del("Content-Length")
+			header.Set("Content-Encoding", encoding)
+			// 压缩后内容不同，强 ETag 改成弱的
+			if etag := header.Get("ETag"); strings.HasPrefix(etag, `"`) {
+				header.Set("ETag", "W/"+etag)
+			}
+			return cw
+		}
+	}
+	return nil
+}
+
+func addVary(header http.Header, field string) {
+	for _, v := range header["Vary"] {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" || strings.EqualFold(f, field) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", field)
+}
@@ -0,0 +1,66 @@
+package web
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+)
+
+var (
+	// 保存 CSRF token 的 cookie
+	CSRFCookie = "_csrf"
+	// 提交 token 的表单字段和请求头
+	CSRFField  = "_csrf"
+	CSRFHeader = "X-CSRF-Token"
+)
+
+// 当前请求的 CSRF token，没有时生成一个并写入 cookie
+func (this *Handler) CSRFToken() string {
+	if len(this.csrfToken) != 0 {
+		return this.csrfToken
+	}
+	if cookie, e := this.Request.Cookie(CSRFCookie); e == nil && len(cookie.Value) == 64 {
+		this.csrfToken = cookie.Value
+		return this.csrfToken
+	}
+	bs := make([]byte, 32)
+	rand.Read(bs)
+	this.csrfToken = hex.EncodeToString(bs)
+	http.SetCookie(this.Writer, &http.Cookie{
+		Name:     CSRFCookie,
+		Value:    this.csrfToken,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   this.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+	return this.csrfToken
+}
+
+// GET/HEAD/OPTIONS 直接通过，其它方法需要表单或请求头中的 token 与 cookie 一致
+func (this *Handler) CheckCSRF() bool {
+	switch this.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	cookie, e := this.Request.Cookie(CSRFCookie)
+	if e != nil || len(cookie.Value) == 0 {
+		return false
+	}
+	token := this.Request.Header.Get(CSRFHeader)
+	if len(token) == 0 {
+		token = this.GetPostStrParam(CSRFField)
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) == 1
+}
+
+// 检查 CSRF token 的中间件，不通过时回复 403
+func CSRF() Middleware {
+	return func(handler IHandler, next func()) {
+		if h, is := handler.(interface{ CheckCSRF() bool }); is && !h.CheckCSRF() {
+			panic(&HTTPError{Code: http.StatusForbidden, Message: "Invalid CSRF token"})
+		}
+		next()
+	}
+}
@@ -0,0 +1,188 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"zwei.ren/log"
+	"zwei.ren/memory"
+)
+
+// 带状态码的错误，Prepare()/Handle() 中 panic 或者通过 Fail() 返回，
+// 由 HttpServer.ErrorHandler 统一输出
+type HTTPError struct {
+	Code    int
+	Message string
+	Details interface{}
+}
+
+func NewHTTPError(code int, message string, details ...interface{}) *HTTPError {
+	e := &HTTPError{Code: code, Message: message}
+	if len(details) == 1 {
+		e.Details = details[0]
+	} else if len(details) > 1 {
+		e.Details = details
+	}
+	return e
+}
+
+func (this *HTTPError) Error() string {
+	if len(this.Message) == 0 {
+		return strconv.Itoa(this.Code) + " " + http.StatusText(this.Code)
+	}
+	return strconv.Itoa(this.Code) + " " + this.Message
+}
+
+// 把任意错误转成 HTTPError，未知错误视为 500 且不向外暴露内容
+func toHTTPError(err interface{}) *HTTPError {
+	switch e := err.(type) {
+	case *HTTPError:
+		return e
+	case HTTPError:
+		return &e
+	case FieldErrors:
+		return &HTTPError{Code: http.StatusBadRequest, Message: "Validation failed", Details: e}
+	case *FieldError:
+		return &HTTPError{Code: http.StatusBadRequest, Message: "Validation failed", Details: FieldErrors{e}}
+	case *json.SyntaxError, *json.UnmarshalTypeError, *xml.SyntaxError:
+		return &HTTPError{Code: http.StatusBadRequest, Message: e.(error).Error()}
+	}
+	switch err {
+	case Err_NotAcceptable:
+		return &HTTPError{Code: http.StatusNotAcceptable}
+	case Err_RequestTooLarge:
+		return &HTTPError{Code: http.StatusRequestEntityTooLarge}
+	case Err_UnsafePath:
+		return &HTTPError{Code: http.StatusBadRequest}
+	case Err_HiddenPath:
+		return &HTTPError{Code: http.StatusNotFound}
+	}
+	return &HTTPError{Code: http.StatusInternalServerError}
+}
+
+// 结束 handler 并交给 ErrorHandler 输出错误
+func (this *Handler) Fail(e error) {
+	if this.err = toHTTPError(e); this.err.Code == http.StatusInternalServerError && !isHTTPError(e) {
+		log.Error("Router handle failed: req[%s] error[%v]", this.Request.URL.Path, e)
+	}
+	this.StopRun()
+}
+
+func isHTTPError(e interface{}) bool {
+	switch e.(type) {
+	case *HTTPError, HTTPError:
+		return true
+	}
+	return false
+}
+
+func (this *Handler) takeError() (e *HTTPError) {
+	e, this.err = this.err, nil
+	return
+}
+
+// StopRun() 直接结束，HTTPError/error 交给 ErrorHandler，其它 panic 回复 500
+func recoverHandler(server *HttpServer, handler IHandler) {
+	if err := recover(); err != nil && err != Err_Abort {
+		request, _ := handler.GetIO()
+		httpErr := toHTTPError(err)
+		if httpErr.Code == http.StatusInternalServerError && !isHTTPError(err) {
+			log.Error("Panic!!!!! at[%v]: %v\nTrace: %v", request.RequestURI, err, memory.PanicTrace(10))
+		}
+		server.renderError(handler, httpErr)
+	} else if e := handler.takeError(); e != nil {
+		server.renderError(handler, e)
+	}
+}
+
+// 调用 ErrorHandler 设置错误的返回，ErrorHandler 自己出错时退回纯文本
+func (this *HttpServer) renderError(handler IHandler, e *HTTPError) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Error("ErrorHandler failed: %v\nTrace: %v", err, memory.PanicTrace(10))
+			handler.ResponseStatus(e.Code)
+			handler.ResponseHeader("Content-Type", "text/plain; charset=utf-8")
+			handler.ResponseData(e.Error())
+		}
+	}()
+	if e.Code < 400 {
+		e = &HTTPError{Code: http.StatusInternalServerError, Message: e.Message, Details: e.Details}
+	}
+	if errorHandler := this.ErrorHandler; errorHandler != nil {
+		errorHandler(handler, e)
+	} else {
+		DefaultErrorHandler(handler, e)
+	}
+}
+
+// RFC 7807 problem details
+type ProblemDetails struct {
+	Type     string      `json:"type"`
+	Title    string      `json:"title"`
+	Status   int         `json:"status"`
+	Detail   string      `json:"detail,omitempty"`
+	Instance string      `json:"instance,omitempty"`
+	Details  interface{} `json:"details,omitempty"`
+}
+
+var errorTpl = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{.Status}} {{.Title}}</title>
+</head>
+<body>
+	<h1>{{.Status}} {{.Title}}</h1>
+	{{if .Detail}}<p>{{.Detail}}</p>{{end}}
+	{{if .Details}}<pre>{{.Details}}</pre>{{end}}
+</body>
+</html>`))
+
+// 浏览器(Accept 中 text/html 优先于 json)返回 HTML 页面，其它返回 application/problem+json
+func DefaultErrorHandler(handler IHandler, e *HTTPError) {
+	request, _ := handler.GetIO()
+	problem := &ProblemDetails{
+		Type:     "about:blank",
+		Title:    http.StatusText(e.Code),
+		Status:   e.Code,
+		Detail:   e.Message,
+		Instance: request.URL.Path,
+		Details:  e.Details,
+	}
+	handler.ResponseStatus(e.Code)
+	if prefersHTML(request.Header.Get("Accept")) {
+		buff := bytes.NewBuffer([]byte{})
+		if problem.Details != nil {
+			if bs, err := json.MarshalIndent(problem.Details, "", "  "); err == nil {
+				problem.Details = string(bs)
+			} else {
+				problem.Details = fmt.Sprint(problem.Details)
+			}
+		}
+		errorTpl.Execute(buff, problem)
+		handler.ResponseHeader("Content-Type", "text/html; charset=utf-8")
+		handler.ResponseData(buff.Bytes())
+	} else {
+		bs, _ := json.Marshal(problem)
+		handler.ResponseHeader("Content-Type", "application/problem+json")
+		handler.ResponseData(bs)
+	}
+}
+
+func prefersHTML(accept string) bool {
+	for _, r := range parseAccept(accept) {
+		if r.mediaType == "text/html" {
+			return true
+		}
+		if strings.Contains(r.mediaType, "json") {
+			return false
+		}
+	}
+	return false
+}
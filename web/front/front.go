@@ -2,6 +2,7 @@ package front
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
@@ -28,6 +29,10 @@ var (
 
 	FrontUpdateKey string
 	FrontDir       = `./`
+	// 每个 IP 提交 /vueupdate 的频率，防止暴力尝试 key。
+	// 按 Handler.IP() 计数，在反向代理之后要先调用 server.SetTrustedProxies，
+	// 否则所有客户端都是代理的 IP，共用这 10 次/分钟
+	UpdateRateLimit = web.NewSlidingWindow(10, time.Minute, nil)
 )
 
 func Init(server *web.HttpServer, key, frontDir string) {
@@ -44,7 +49,7 @@ func Init(server *web.HttpServer, key, frontDir string) {
 	server.AddGZipStaticRouter("/static/", path.Join(FrontDir, "dist", "static"), true, ".js", ".css", ".html")
 	// server.AddRouter("/favicon.ico", func() web.IHandler { return new(IconHandler) })
 	if len(FrontUpdateKey) != 0 {
-		server.AddRouter("/vueupdate", func() web.IHandler { return new(VueUpdateHandler) })
+		server.Group("/vueupdate", UpdateRateLimit.Middleware()).AddRouter("/", func() web.IHandler { return new(VueUpdateHandler) })
 	}
 	server.AddRouter("/", func() web.IHandler { return new(HomeHandler) })
 }
@@ -76,7 +81,11 @@ func (this *HomeHandler) Handle() {
 		fileName = "index.html"
 	}
 
-	if bs, e := file.ReadFile(path.Join(FrontDir, "dist", fileName), 0, -1); e == nil {
+	filePath, e := this.SafeFilePath(path.Join(FrontDir, "dist"), fileName, false)
+	if e == web.Err_UnsafePath || e == web.Err_HiddenPath {
+		this.Fail(e)
+	}
+	if bs, e := file.ReadFile(filePath, 0, -1); e == nil {
 		this.ResponseOK()
 		this.ResponseData(bs)
 		this.ResponseHeaders(map[string][]string{
@@ -130,11 +139,13 @@ func (this *VueUpdateHandler) Handle() {
 
 			distDir := path.Join(FrontDir, "dist")
 
-			if f := this.GetFileParam("file"); f == nil || len(f.Content) == 0 {
+			if f := this.GetFileParam("file"); f == nil || f.Size == 0 {
 				if zipUrl := this.GetPostStrParam("url"); len(zipUrl) == 0 || !strings.HasPrefix(zipUrl, "http") { // 没有url
 					if version := this.GetPostStrParam("version"); len(version) == 0 || version == "空" {
 						respMsg = "未传入文件或版本号"
-					} else if bs, e := file.ReadFile(path.Join(histroyDir, version+".zip"), 0, -1); e == nil { // 恢复历史版本
+					} else if zipPath, e := web.SafeJoin(histroyDir, version+".zip", false); e == web.Err_UnsafePath || e == web.Err_HiddenPath {
+						respMsg = "版本号错误"
+					} else if bs, e := file.ReadFile(zipPath, 0, -1); e == nil { // 恢复历史版本
 						if e := zip.UnZipFolder(tempDir, bs, true); e == nil {
 							file.DeletePath(distDir)
 							if e = os.Rename(path.Join(tempDir, "dist"), distDir); e == nil {
@@ -149,7 +160,7 @@ func (this *VueUpdateHandler) Handle() {
 					} else {
 						respMsg = "该版本不存在"
 					}
-				} else if zipBytes, e := HttpGet(zipUrl); e != nil { // 有url
+				} else if zipBytes, e := HttpGetContext(this.Context(), zipUrl); e != nil { // 有url
 					respMsg = "服务器下载链接失败"
 				} else if e := zip.UnZipFolder(tempDir, zipBytes, true); e == nil {
 					file.DeletePath(distDir)
@@ -158,7 +169,7 @@ func (this *VueUpdateHandler) Handle() {
 
 						if e = file.EnsureDir(histroyDir); e == nil {
 							versionName := time.Now().Format("20060102150405")
-							if e = file.WriteFile(path.Join(histroyDir, versionName+".zip"), f.Content, true, true); e == nil {
+							if e = file.WriteFile(path.Join(histroyDir, versionName+".zip"), zipBytes, true, true); e == nil {
 								respMsg += ", 该版本号为:" + versionName
 							}
 						}
@@ -169,9 +180,11 @@ func (this *VueUpdateHandler) Handle() {
 				} else {
 					respMsg = "解压失败:" + e.Error()
 				}
-			} else if md5 := encrypt.MD5(f.Content); bytes.Equal(md5, lastMD5) {
+			} else if content, e := f.Bytes(); e != nil {
+				respMsg = "读取上传文件失败"
+			} else if md5 := encrypt.MD5(content); bytes.Equal(md5, lastMD5) {
 				respMsg = "和上一个任务相同的文件，不作处理"
-			} else if e := zip.UnZipFolder(tempDir, f.Content, true); e == nil {
+			} else if e := zip.UnZipFolder(tempDir, content, true); e == nil {
 				file.DeletePath(distDir)
 				if e = os.Rename(path.Join(tempDir, "dist"), distDir); e == nil {
 					respMsg = "更新成功"
@@ -179,7 +192,7 @@ func (this *VueUpdateHandler) Handle() {
 
 					if e = file.EnsureDir(histroyDir); e == nil {
 						versionName := time.Now().Format("20060102150405")
-						if e = file.WriteFile(path.Join(histroyDir, versionName+".zip"), f.Content, true, true); e == nil {
+						if e = file.WriteFile(path.Join(histroyDir, versionName+".zip"), content, true, true); e == nil {
 							respMsg += ", 该版本号为:" + versionName
 						}
 					}
@@ -262,8 +275,14 @@ var (
 )
 
 func HttpGet(url string) (bs []byte, e error) {
+	return HttpGetContext(context.Background(), url)
+}
+
+// ctx 中有请求 ID 时一起转发
+func HttpGetContext(ctx context.Context, url string) (bs []byte, e error) {
 	var httpReq *http.Request
-	if httpReq, e = http.NewRequest("GET", url, nil); e == nil {
+	if httpReq, e = http.NewRequestWithContext(ctx, "GET", url, nil); e == nil {
+		web.ForwardRequestID(ctx, httpReq)
 		var httpRes *http.Response
 		client := new(http.Client)
 		client.CheckRedirect = func(*http.Request, []*http.Request) error {
@@ -0,0 +1,134 @@
+package web
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// unix socket 文件的权限
+	UnixSocketMode os.FileMode = 0660
+
+	Err_SocketInUse = errors.New("Unix socket is in use")
+)
+
+// 按地址创建监听：
+//
+//	":8080"、"127.0.0.1:8080"、"[::1]:0"  TCP
+//	"unix:/run/app.sock"                  unix socket，权限为 UnixSocketMode
+//	"fd:3"                                继承的文件描述符(systemd socket activation 等)
+func Listen(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return listenUnix(strings.TrimPrefix(addr, "unix:"))
+	case strings.HasPrefix(addr, "fd:"):
+		fd, e := strconv.Atoi(strings.TrimPrefix(addr, "fd:"))
+		if e != nil {
+			return nil, e
+		}
+		file := os.NewFile(uintptr(fd), addr)
+		defer file.Close()
+		return net.FileListener(file)
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+func listenUnix(socketPath string) (net.Listener, error) {
+	// 上次没有正常退出留下的 socket 文件，没有进程在监听时删掉
+	if info, e := os.Lstat(socketPath); e == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, e := net.DialTimeout("unix", socketPath, time.Second); e == nil {
+			conn.Close()
+			return nil, Err_SocketInUse
+		}
+		os.Remove(socketPath)
+	}
+	listener, e := net.Listen("unix", socketPath)
+	if e != nil {
+		return nil, e
+	}
+	// 关闭时删除 socket 文件
+	listener.(*net.UnixListener).SetUnlinkOnClose(true)
+	if e = os.Chmod(socketPath, UnixSocketMode); e != nil {
+		listener.Close()
+		return nil, e
+	}
+	return listener, nil
+}
+
+// 路由分发，可以挂在其它 http.Server 或 httptest 上
+func (this *HttpServer) Handler() http.Handler {
+	return http.HandlerFunc(this.serveHTTP)
+}
+
+// 在已经打开的 listener 上运行，可以多次调用同时监听多个地址，Shutdown 时一起关闭
+func Serve(listener net.Listener) error {
+	return DefaultServer.Serve(listener)
+}
+
+func (this *HttpServer) Serve(listener net.Listener) error {
+	server, e := this.prepare(listener.Addr().String(), ReadTimeout, WriteTimeout, false)
+	if e != nil {
+		listener.Close()
+		return e
+	}
+	return this.serve(server, listener)
+}
+
+func (this *HttpServer) serve(server *http.Server, listener net.Listener) error {
+	return this.logRun("address", listener.Addr().String(), func() error {
+		return server.Serve(listener)
+	})
+}
+
+// 监听 addr 并运行，addr 的格式见 Listen
+func RunAddr(addr string) error {
+	return DefaultServer.RunAddr(addr)
+}
+
+func (this *HttpServer) RunAddr(addr string) error {
+	listener, e := Listen(addr)
+	if e != nil {
+		return e
+	}
+	return this.Serve(listener)
+}
+
+// 监听成功后在后台运行，返回实际监听的地址(port 为0时由系统分配)，
+// 服务停止时 chan 收到错误
+func AsyncRun(port int) (net.Addr, chan error) {
+	return DefaultServer.AsyncRun(port)
+}
+
+func (this *HttpServer) AsyncRun(port int) (net.Addr, chan error) {
+	return this.AsyncRunAddr(":" + strconv.Itoa(port))
+}
+
+func AsyncRunAddr(addr string) (net.Addr, chan error) {
+	return DefaultServer.AsyncRunAddr(addr)
+}
+
+func (this *HttpServer) AsyncRunAddr(addr string) (net.Addr, chan error) {
+	result := make(chan error, 1)
+	listener, e := Listen(addr)
+	if e != nil {
+		result <- e
+		return nil, result
+	}
+	// 返回之前创建好 http.Server，之后马上调用 Shutdown 也能关闭
+	server, e := this.prepare(listener.Addr().String(), ReadTimeout, WriteTimeout, false)
+	if e != nil {
+		listener.Close()
+		result <- e
+		return nil, result
+	}
+	go func() {
+		result <- this.serve(server, listener)
+	}()
+	return listener.Addr(), result
+}
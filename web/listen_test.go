@@ -0,0 +1,81 @@
+package web
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type pingHandler struct {
+	Handler
+}
+
+func (this *pingHandler) Handle() {
+	this.ResponseOK()
+	this.ResponseData("pong")
+}
+
+func TestServeListeners(t *testing.T) {
+	server := &HttpServer{}
+	server.AddRouter("/ping", func() IHandler { return new(pingHandler) })
+	socket := filepath.Join(t.TempDir(), "web.sock")
+	tcpAddr, tcpDone := server.AsyncRunAddr("127.0.0.1:0")
+	unixAddr, unixDone := server.AsyncRunAddr("unix:" + socket)
+	if tcpAddr == nil || unixAddr == nil {
+		t.Fatal(<-tcpDone, <-unixDone)
+	}
+
+	get := func(network, addr string) string {
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		}}
+		res, e := client.Get("http://localhost/ping")
+		if e != nil {
+			t.Fatal(network, e)
+		}
+		defer res.Body.Close()
+		bs, _ := io.ReadAll(res.Body)
+		return string(bs)
+	}
+	if body := get("tcp", tcpAddr.String()); body != "pong" {
+		t.Error("tcp:", body)
+	}
+	if body := get("unix", socket); body != "pong" {
+		t.Error("unix:", body)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if e := server.Shutdown(ctx); e != nil {
+		t.Fatal(e)
+	}
+	for _, done := range []chan error{tcpDone, unixDone} {
+		if e := <-done; e != http.ErrServerClosed {
+			t.Error(e)
+		}
+	}
+	if _, e := os.Lstat(socket); !os.IsNotExist(e) {
+		t.Error("socket file left after shutdown:", e)
+	}
+	if e := server.Shutdown(ctx); e != Err_NotRunning {
+		t.Error(e)
+	}
+
+	// 关闭之后可以重新运行
+	addr, done := server.AsyncRunAddr("127.0.0.1:0")
+	if addr == nil {
+		t.Fatal(<-done)
+	}
+	if body := get("tcp", addr.String()); body != "pong" || !server.Ready() {
+		t.Error("rerun:", body)
+	}
+	server.Shutdown(ctx)
+	<-done
+}
@@ -0,0 +1,228 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AddFileRouter/AddStaticRouter 的可选配置
+type StaticOption func(router *staticRouter)
+
+// 目录下没有 index.html 时列出目录内容，showHidden 为 true 时同时列出 . 开头的文件
+//
+// 支持的参数：sort=name|size|mtime|type，order=asc|desc，page，pageSize，format=json
+func WithListing(showHidden bool) StaticOption {
+	return func(router *staticRouter) {
+		router.Listing = true
+		router.ShowHidden = showHidden
+	}
+}
+
+var (
+	// 目录列表每页默认的数量和最大数量
+	ListingPageSize    = 100
+	ListingMaxPageSize = 1000
+)
+
+type DirEntry struct {
+	Name     string `json:"name"`
+	IsDir    bool   `json:"isDir"`
+	Size     int64  `json:"size"`
+	ModifyAt int64  `json:"modifyAt"`
+	Type     string `json:"type"`
+}
+
+type DirListing struct {
+	Path     string     `json:"path"`
+	Total    int        `json:"total"`
+	Page     int        `json:"page"`
+	PageSize int        `json:"pageSize"`
+	Sort     string     `json:"sort"`
+	Order    string     `json:"order"`
+	Items    []DirEntry `json:"items"`
+}
+
+var listingTpl = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"href": func(entry DirEntry) string {
+		if entry.IsDir {
+			return url.PathEscape(entry.Name) + "/"
+		}
+		return url.PathEscape(entry.Name)
+	},
+	"time": func(unix int64) string {
+		return time.Unix(unix, 0).Format("2006-01-02 15:04:05")
+	},
+	"sortBy": func(listing *DirListing, key string) string {
+		order := "asc"
+		if listing.Sort == key && listing.Order == "asc" {
+			order = "desc"
+		}
+		return "?sort=" + key + "&order=" + order + "&pageSize=" + strconv.Itoa(listing.PageSize)
+	},
+	"page": func(listing *DirListing, page int) string {
+		return "?sort=" + listing.Sort + "&order=" + listing.Order +
+			"&page=" + strconv.Itoa(page) + "&pageSize=" + strconv.Itoa(listing.PageSize)
+	},
+	"add": func(a, b int) int { return a + b },
+	"hasNext": func(listing *DirListing) bool {
+		return listing.Page*listing.PageSize < listing.Total
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>Index of {{.Path}}</title>
+	<style>
+		body { font-family: monospace; }
+		td, th { padding: 2px 12px; text-align: left; }
+	</style>
+</head>
+<body>
+	<h1>Index of {{.Path}}</h1>
+	<table>
+		<tr>
+			<th><a href="{{sortBy . "name"}}">Name</a></th>
+			<th><a href="{{sortBy . "size"}}">Size</a></th>
+			<th><a href="{{sortBy . "mtime"}}">Modified</a></th>
+			<th><a href="{{sortBy . "type"}}">Type</a></th>
+		</tr>
+		{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td><td></td></tr>{{end}}
+		{{range .Items}}<tr>
+			<td><a href="{{href .}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td>
+			<td>{{if not .IsDir}}{{.Size}}{{end}}</td>
+			<td>{{time .ModifyAt}}</td>
+			<td>{{.Type}}</td>
+		</tr>
+		{{end}}
+	</table>
+	<p>
+		{{if gt .Page 1}}<a href="{{page . (add .Page -1)}}">&laquo; Prev</a>{{end}}
+		Page {{.Page}}, {{.Total}} items
+		{{if hasNext .}}<a href="{{page . (add .Page 1)}}">Next &raquo;</a>{{end}}
+	</p>
+</body>
+</html>`))
+
+// 列出目录，segs 为目录相对于 Folder 的路径，用于调用 Filter
+func (this *staticRouter) responseListing(dirPath string, segs []string) {
+	rel := []string{}
+	for _, s := range segs {
+		if len(s) != 0 {
+			rel = append(rel, s)
+		}
+	}
+
+	infos, _ := ioutil.ReadDir(dirPath)
+	items := make([]DirEntry, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		if !this.ShowHidden && strings.HasPrefix(name, ".") {
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			// 不列出指向 Folder 外面的软链接，其它的按指向的文件显示
+			if _, e := SafeJoin(this.Folder, path.Join(append(rel[:len(rel):len(rel)], name)...), true); e == Err_UnsafePath {
+				continue
+			}
+			var e error
+			if info, e = os.Stat(path.Join(dirPath, name)); e != nil {
+				continue
+			}
+		}
+		if !this.NoFilter && !this.Filter(append(rel[:len(rel):len(rel)], name), info) {
+			continue
+		}
+		entry := DirEntry{
+			Name:     name,
+			IsDir:    info.IsDir(),
+			Size:     info.Size(),
+			ModifyAt: info.ModTime().Unix(),
+			Type:     "directory",
+		}
+		if !entry.IsDir {
+			if entry.Type = mime.TypeByExtension(path.Ext(name)); len(entry.Type) == 0 {
+				entry.Type = "application/octet-stream"
+			}
+		}
+		items = append(items, entry)
+	}
+
+	listing := &DirListing{
+		Path:     this.Request.URL.Path,
+		Total:    len(items),
+		Sort:     this.GetGetParam("sort"),
+		Order:    this.GetGetParam("order"),
+		Page:     this.GetGetIntParam("page", 1),
+		PageSize: this.GetGetIntParam("pageSize", ListingPageSize),
+	}
+	if listing.Page < 1 {
+		listing.Page = 1
+	}
+	if listing.PageSize < 1 || listing.PageSize > ListingMaxPageSize {
+		listing.PageSize = ListingPageSize
+	}
+	if listing.Order != "desc" {
+		listing.Order = "asc"
+	}
+	var less func(a, b *DirEntry) bool
+	switch listing.Sort {
+	case "size":
+		less = func(a, b *DirEntry) bool { return a.Size < b.Size }
+	case "mtime":
+		less = func(a, b *DirEntry) bool { return a.ModifyAt < b.ModifyAt }
+	case "type":
+		less = func(a, b *DirEntry) bool { return a.Type < b.Type }
+	default:
+		listing.Sort = "name"
+		less = func(a, b *DirEntry) bool { return false }
+	}
+	// 目录总是在前面，相同时按名称
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := &items[i], &items[j]
+		if a.IsDir != b.IsDir {
+			return a.IsDir
+		}
+		if listing.Order == "desc" {
+			a, b = b, a
+		}
+		if less(a, b) {
+			return true
+		} else if less(b, a) {
+			return false
+		}
+		return a.Name < b.Name
+	})
+	if from := (listing.Page - 1) * listing.PageSize; from < len(items) {
+		to := from + listing.PageSize
+		if to > len(items) {
+			to = len(items)
+		}
+		listing.Items = items[from:to]
+	} else {
+		listing.Items = []DirEntry{}
+	}
+
+	if this.GetGetParam("format") == "json" || !prefersHTML(this.GetHeader("accept")) && strings.Contains(this.GetHeader("accept"), "json") {
+		bs, _ := json.Marshal(listing)
+		this.ResponseHeader("Content-Type", "application/json; charset=utf-8")
+		this.ResponseData(bs)
+	} else {
+		buff := bytes.NewBuffer([]byte{})
+		if e := listingTpl.Execute(buff, listing); e != nil {
+			this.Fail(e)
+		}
+		this.ResponseHeader("Content-Type", "text/html; charset=utf-8")
+		this.ResponseData(buff.Bytes())
+	}
+	this.ResponseOK()
+}
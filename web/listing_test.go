@@ -0,0 +1,60 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListing(t *testing.T) {
+	dir := t.TempDir()
+	for _, p := range []string{"a.txt", ".env", ".git/config", "sub/b.txt"} {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(p)), 0755)
+		if e := os.WriteFile(filepath.Join(dir, p), []byte(p), 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+	server := &HttpServer{}
+	server.AddStaticRouter("/static", dir, WithListing(false))
+	server.AddStaticRouter("/all", dir, WithListing(true))
+	list := func(p string) (int, []string) {
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest("GET", p+"?format=json", nil))
+		var listing DirListing
+		json.Unmarshal(rec.Body.Bytes(), &listing)
+		names := []string{}
+		for _, item := range listing.Items {
+			names = append(names, item.Name)
+		}
+		return rec.Code, names
+	}
+
+	cases := []struct {
+		path   string
+		status int
+		names  []string
+	}{
+		{"/static/", http.StatusOK, []string{"sub", "a.txt"}},
+		{"/static/sub/", http.StatusOK, []string{"b.txt"}},
+		{"/static/.git/", http.StatusNotFound, []string{}},
+		{"/static/sub/../.git/", http.StatusBadRequest, []string{}},
+		{"/all/", http.StatusOK, []string{".git", "sub", ".env", "a.txt"}},
+		{"/all/.git/", http.StatusOK, []string{"config"}},
+	}
+	for _, c := range cases {
+		status, names := list(c.path)
+		if status != c.status || len(names) != len(c.names) {
+			t.Errorf("%s: %d %v", c.path, status, names)
+			continue
+		}
+		for i := range names {
+			if names[i] != c.names[i] {
+				t.Errorf("%s: %v", c.path, names)
+				break
+			}
+		}
+	}
+}
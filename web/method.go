@@ -0,0 +1,171 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+)
+
+// 实现了下面任意一个接口的 handler，会按请求的 method 调用对应的方法
+// 如果同时实现了 Handle()，没有对应方法的请求交给 Handle()，否则回复 405
+type IGetHandler interface {
+	Get()
+}
+type IPostHandler interface {
+	Post()
+}
+type IPutHandler interface {
+	Put()
+}
+type IDeleteHandler interface {
+	Delete()
+}
+type IPatchHandler interface {
+	Patch()
+}
+
+var allMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// handler 实现了哪些 method 方法
+func handlerMethods(handler IHandler) (methods []string) {
+	if _, is := handler.(IGetHandler); is {
+		methods = append(methods, http.MethodGet)
+	}
+	if _, is := handler.(IPostHandler); is {
+		methods = append(methods, http.MethodPost)
+	}
+	if _, is := handler.(IPutHandler); is {
+		methods = append(methods, http.MethodPut)
+	}
+	if _, is := handler.(IDeleteHandler); is {
+		methods = append(methods, http.MethodDelete)
+	}
+	if _, is := handler.(IPatchHandler); is {
+		methods = append(methods, http.MethodPatch)
+	}
+	return
+}
+
+// HEAD 由 Get() 处理
+func hasMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method || m == http.MethodGet && method == http.MethodHead {
+			return true
+		}
+	}
+	return false
+}
+
+// 调用 method 对应的方法，没有实现时返回false
+func callMethodHandler(handler IHandler, method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead:
+		if h, is := handler.(IGetHandler); is {
+			h.Get()
+			return true
+		}
+	case http.MethodPost:
+		if h, is := handler.(IPostHandler); is {
+			h.Post()
+			return true
+		}
+	case http.MethodPut:
+		if h, is := handler.(IPutHandler); is {
+			h.Put()
+			return true
+		}
+	case http.MethodDelete:
+		if h, is := handler.(IDeleteHandler); is {
+			h.Delete()
+			return true
+		}
+	case http.MethodPatch:
+		if h, is := handler.(IPatchHandler); is {
+			h.Patch()
+			return true
+		}
+	}
+	return false
+}
+
+// 返回处理该 method 的 builder，为nil时表示不支持，allow 为 Allow 头的内容
+func (this *_Router) resolve(method string) (builder func() IHandler, allow string) {
+	if builder = this.Methods[method]; builder != nil {
+		return
+	}
+	if method == http.MethodHead {
+		if builder = this.Methods[http.MethodGet]; builder != nil {
+			return
+		}
+	}
+	if this.Builder != nil {
+		if this.AnyMethods == nil {
+			return this.Builder, ""
+		}
+		if hasMethod(this.AnyMethods, method) {
+			return this.Builder, ""
+		}
+	}
+	return nil, this.allow()
+}
+
+func (this *_Router) allow() string {
+	allowed := map[string]bool{http.MethodOptions: true}
+	for m := range this.Methods {
+		allowed[m] = true
+	}
+	if this.Builder != nil {
+		for _, m := range this.AnyMethods {
+			allowed[m] = true
+		}
+	}
+	if allowed[http.MethodGet] {
+		allowed[http.MethodHead] = true
+	}
+	res := make([]string, 0, len(allowed))
+	for _, m := range allMethods {
+		if allowed[m] {
+			res = append(res, m)
+			delete(allowed, m)
+		}
+	}
+	for m := range allowed {
+		res = append(res, m)
+	}
+	return strings.Join(res, ", ")
+}
+
+func AddMethodRouter(method, httpPath string, handlerBuilder func() IHandler, name ...string) {
+	DefaultServer.AddMethodRouter(method, httpPath, handlerBuilder, name...)
+}
+
+// 只处理 method 请求的路由，同一路径的其它 method 会收到 405
+func (this *HttpServer) AddMethodRouter(method, httpPath string, handlerBuilder func() IHandler, name ...string) {
+	if method = strings.ToUpper(method); len(method) == 0 {
+		panic("Router [" + httpPath + "] wrong: empty method")
+	}
+	this.addRouter(method, httpPath, handlerBuilder, name...)
+}
+
+func (this *HttpServer) Get(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.AddMethodRouter(http.MethodGet, httpPath, handlerBuilder, name...)
+}
+func (this *HttpServer) Post(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.AddMethodRouter(http.MethodPost, httpPath, handlerBuilder, name...)
+}
+func (this *HttpServer) Put(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.AddMethodRouter(http.MethodPut, httpPath, handlerBuilder, name...)
+}
+func (this *HttpServer) Delete(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.AddMethodRouter(http.MethodDelete, httpPath, handlerBuilder, name...)
+}
+func (this *HttpServer) Patch(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.AddMethodRouter(http.MethodPatch, httpPath, handlerBuilder, name...)
+}
@@ -0,0 +1,43 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type getOnlyHandler struct {
+	Handler
+}
+
+func (this *getOnlyHandler) Get() {
+	this.ResponseOK()
+	this.ResponseData("get")
+}
+
+func TestMethodRouterNeedsHook(t *testing.T) {
+	server := &HttpServer{}
+	server.Get("/item", func() IHandler { return new(getOnlyHandler) })
+	server.AddMethodRouter(http.MethodHead, "/head", func() IHandler { return new(getOnlyHandler) })
+
+	func() {
+		defer func() {
+			if e := recover(); e == nil || !strings.Contains(e.(string), Err_MethodUnimplemented.Error()) {
+				t.Fatal("expect registration to fail, got", e)
+			}
+		}()
+		server.Post("/item", func() IHandler { return new(getOnlyHandler) })
+	}()
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/item", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	rec = httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/item", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "get" {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+}
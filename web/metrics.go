@@ -0,0 +1,311 @@
+package web
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// 请求耗时直方图的区间(秒)
+	MetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+	processStart = time.Now()
+
+	collectorLock sync.RWMutex
+	collectors    = map[string]*collector{}
+)
+
+// 其它包注册的指标，在输出时调用 fn 取值
+type collector struct {
+	name, help, kind string
+	fn               func() float64
+}
+
+// 注册一个 gauge，例如连接数、缓存大小，name 相同时替换
+func RegisterGauge(name, help string, fn func() float64) {
+	registerCollector(&collector{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+// 注册一个只增不减的 counter
+func RegisterCounter(name, help string, fn func() float64) {
+	registerCollector(&collector{name: name, help: help, kind: "counter", fn: fn})
+}
+
+func registerCollector(c *collector) {
+	collectorLock.Lock()
+	defer collectorLock.Unlock()
+	collectors[c.name] = c
+}
+
+type requestKey struct {
+	route, method string
+	status        int
+}
+
+type latencyKey struct {
+	route, method string
+}
+
+type histogram struct {
+	counts []uint64 // 与 MetricsBuckets 对应，不累加
+	count  uint64
+	sum    float64
+}
+
+// 一个 HttpServer 的请求统计
+type metrics struct {
+	lock      sync.Mutex
+	buckets   []float64
+	requests  map[requestKey]uint64
+	latencies map[latencyKey]*histogram
+	inFlight  int64
+	longLived int64 // websocket、SSE 等长连接
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		buckets:   append([]float64{}, MetricsBuckets...),
+		requests:  map[requestKey]uint64{},
+		latencies: map[latencyKey]*histogram{},
+	}
+}
+
+// 标准方法以外的都记为 OTHER，避免标签过多
+func metricsMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+		http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+func (this *metrics) begin() {
+	atomic.AddInt64(&this.inFlight, 1)
+}
+
+// 请求变成长连接，不再算作 in-flight
+func (this *metrics) detach() {
+	atomic.AddInt64(&this.inFlight, -1)
+	atomic.AddInt64(&this.longLived, 1)
+}
+
+// 请求结束时调用，没有匹配到路由的请求记为 unmatched，
+// 长连接只计数，持续时间不计入耗时直方图
+func (this *metrics) observe(
+	status_ptr *int, from time.Time,
+	request *http.Request,
+	writer *responseWriter,
+	route **_Router,
+) {
+	if writer.detached {
+		atomic.AddInt64(&this.longLived, -1)
+	} else {
+		atomic.AddInt64(&this.inFlight, -1)
+	}
+	label := "unmatched"
+	if route != nil && *route != nil {
+		label = routeLabel(*route)
+	}
+	method := metricsMethod(request.Method)
+	seconds := time.Since(from).Seconds()
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.requests[requestKey{label, method, writer.Status(*status_ptr)}]++
+	if writer.detached {
+		return
+	}
+	key := latencyKey{label, method}
+	h := this.latencies[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(this.buckets))}
+		this.latencies[key] = h
+	}
+	h.count++
+	h.sum += seconds
+	if i := sort.SearchFloat64s(this.buckets, seconds); i < len(this.buckets) {
+		h.counts[i]++
+	}
+}
+
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type metricsWriter struct {
+	*bufio.Writer
+}
+
+func (this metricsWriter) header(name, help, kind string) {
+	this.WriteString("# HELP " + name + " " + help + "\n# TYPE " + name + " " + kind + "\n")
+}
+
+// labels 按 key, value 成对传入
+func (this metricsWriter) sample(name string, value float64, labels ...string) {
+	this.WriteString(name)
+	if len(labels) != 0 {
+		this.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i != 0 {
+				this.WriteString(",")
+			}
+			this.WriteString(labels[i] + `="` + escapeLabel(labels[i+1]) + `"`)
+		}
+		this.WriteString("}")
+	}
+	this.WriteString(" " + formatFloat(value) + "\n")
+}
+
+func (this *metrics) writeTo(w metricsWriter) {
+	this.lock.Lock()
+	requests := make([]requestKey, 0, len(this.requests))
+	for k := range this.requests {
+		requests = append(requests, k)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		a, b := requests[i], requests[j]
+		if a.route != b.route {
+			return a.route < b.route
+		} else if a.method != b.method {
+			return a.method < b.method
+		}
+		return a.status < b.status
+	})
+	w.header("http_requests_total", "Total HTTP requests by route, method and status.", "counter")
+	for _, k := range requests {
+		w.sample("http_requests_total", float64(this.requests[k]), "route", k.route, "method", k.method, "status", strconv.Itoa(k.status))
+	}
+
+	latencies := make([]latencyKey, 0, len(this.latencies))
+	for k := range this.latencies {
+		latencies = append(latencies, k)
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		if latencies[i].route != latencies[j].route {
+			return latencies[i].route < latencies[j].route
+		}
+		return latencies[i].method < latencies[j].method
+	})
+	w.header("http_request_duration_seconds", "HTTP request latency by route and method.", "histogram")
+	for _, k := range latencies {
+		h := this.latencies[k]
+		var cumulative uint64
+		for i, le := range this.buckets {
+			cumulative += h.counts[i]
+			w.sample("http_request_duration_seconds_bucket", float64(cumulative), "route", k.route, "method", k.method, "le", formatFloat(le))
+		}
+		w.sample("http_request_duration_seconds_bucket", float64(h.count), "route", k.route, "method", k.method, "le", "+Inf")
+		w.sample("http_request_duration_seconds_sum", h.sum, "route", k.route, "method", k.method)
+		w.sample("http_request_duration_seconds_count", float64(h.count), "route", k.route, "method", k.method)
+	}
+	this.lock.Unlock()
+
+	w.header("http_requests_in_flight", "HTTP requests being served.", "gauge")
+	w.sample("http_requests_in_flight", float64(atomic.LoadInt64(&this.inFlight)))
+	w.header("http_long_lived_connections", "Websocket and SSE connections being served.", "gauge")
+	w.sample("http_long_lived_connections", float64(atomic.LoadInt64(&this.longLived)))
+}
+
+func writeCollectors(w metricsWriter) {
+	collectorLock.RLock()
+	list := make([]*collector, 0, len(collectors))
+	for _, c := range collectors {
+		list = append(list, c)
+	}
+	collectorLock.RUnlock()
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].name < list[j].name
+	})
+	for _, c := range list {
+		w.header(c.name, c.help, c.kind)
+		w.sample(c.name, c.fn())
+	}
+}
+
+func writeRuntime(w metricsWriter) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	w.header("go_info", "Go version.", "gauge")
+	w.sample("go_info", 1, "version", runtime.Version())
+	w.header("go_goroutines", "Number of goroutines.", "gauge")
+	w.sample("go_goroutines", float64(runtime.NumGoroutine()))
+	w.header("go_memstats_alloc_bytes", "Bytes of allocated heap objects.", "gauge")
+	w.sample("go_memstats_alloc_bytes", float64(stats.Alloc))
+	w.header("go_memstats_sys_bytes", "Bytes of memory obtained from the OS.", "gauge")
+	w.sample("go_memstats_sys_bytes", float64(stats.Sys))
+	w.header("go_memstats_heap_objects", "Number of allocated heap objects.", "gauge")
+	w.sample("go_memstats_heap_objects", float64(stats.HeapObjects))
+	w.header("go_gc_cycles_total", "Number of completed GC cycles.", "counter")
+	w.sample("go_gc_cycles_total", float64(stats.NumGC))
+	w.header("go_gc_pause_seconds_total", "Total GC stop-the-world pause time.", "counter")
+	w.sample("go_gc_pause_seconds_total", float64(stats.PauseTotalNs)/1e9)
+	w.header("process_start_time_seconds", "Start time of the process since unix epoch.", "gauge")
+	w.sample("process_start_time_seconds", float64(processStart.UnixNano())/1e9)
+}
+
+// 输出 Prometheus 文本格式的指标
+func (this *HttpServer) WriteMetrics(out io.Writer) error {
+	w := metricsWriter{bufio.NewWriter(out)}
+	if metrics := this.getMetrics(); metrics != nil {
+		metrics.writeTo(w)
+	}
+	writeCollectors(w)
+	writeRuntime(w)
+	return w.Flush()
+}
+
+// 开始统计请求并在 httpPath 输出 Prometheus 指标，通常为 /metrics
+func AddMetricsRouter(httpPath string) {
+	DefaultServer.AddMetricsRouter(httpPath)
+}
+
+func (this *HttpServer) AddMetricsRouter(httpPath string) {
+	this.routeLock.Lock()
+	if this.metrics == nil {
+		this.metrics = newMetrics()
+	}
+	this.routeLock.Unlock()
+	this.AddRouter(httpPath, func() IHandler {
+		return &metricsHandler{server: this}
+	})
+}
+
+func (this *HttpServer) getMetrics() *metrics {
+	this.routeLock.RLock()
+	defer this.routeLock.RUnlock()
+	return this.metrics
+}
+
+type metricsHandler struct {
+	Handler
+	server *HttpServer
+}
+
+func (this *metricsHandler) Handle() {
+	buff := &strings.Builder{}
+	this.server.WriteMetrics(buff)
+	this.ResponseOK()
+	this.ResponseHeader("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	this.ResponseHeader("Cache-Control", "no-store")
+	this.ResponseData(buff.String())
+}
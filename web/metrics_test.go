@@ -0,0 +1,63 @@
+package web
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type holdStreamHandler struct {
+	Handler
+	opened, done chan struct{}
+}
+
+func (this *holdStreamHandler) Handle() {
+	stream, e := this.SSE()
+	if e != nil {
+		panic(e)
+	}
+	stream.SendData("hello")
+	this.opened <- struct{}{}
+	<-this.done
+}
+
+func TestMetricsLongLived(t *testing.T) {
+	opened, release := make(chan struct{}), make(chan struct{})
+	server := &HttpServer{}
+	server.AddRouter("/events", func() IHandler {
+		return &holdStreamHandler{opened: opened, done: release}
+	})
+	server.AddMetricsRouter("/metrics")
+	scrape := func() string {
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		return rec.Body.String()
+	}
+	check := func(body string, wants map[string]bool) {
+		for want, has := range wants {
+			if strings.Contains(body, want) != has {
+				t.Errorf("%q: expect %v\n%s", want, has, body)
+			}
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/events", nil))
+	}()
+	<-opened
+	// 只有正在抓取的这个请求
+	check(scrape(), map[string]bool{
+		"http_requests_in_flight 1\n":     true,
+		"http_long_lived_connections 1\n": true,
+	})
+	close(release)
+	<-done
+	check(scrape(), map[string]bool{
+		"http_requests_in_flight 1\n":                                      true,
+		"http_long_lived_connections 0\n":                                  true,
+		`http_requests_total{route="/events",method="GET",status="200"} 1`: true,
+		`http_request_duration_seconds_count{route="/events"`:              false,
+	})
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// 中间件包裹着 Prepare()/Handle()，调用 next() 继续往下执行
+// 不调用 next() 或者调用 handler.StopRun() 都可以直接结束，已经设置的 Response 会照常返回
+// next() 返回后可以通过 handler.GetResponse() 拿到最终的 status/headers/data
+type Middleware func(handler IHandler, next func())
+
+func Use(middlewares ...Middleware) {
+	DefaultServer.Use(middlewares...)
+}
+
+// 添加对所有路由都生效的中间件
+func (this *HttpServer) Use(middlewares ...Middleware) {
+	this.routeLock.Lock()
+	defer this.routeLock.Unlock()
+	this.middlewares = append(this.middlewares, middlewares...)
+}
+
+type RouterGroup struct {
+	server      *HttpServer
+	parent      *RouterGroup
+	prefix      string
+	middlewares []Middleware
+}
+
+func Group(prefix string, middlewares ...Middleware) *RouterGroup {
+	return DefaultServer.Group(prefix, middlewares...)
+}
+
+// 路由组，组内的路由都以 prefix 开头，并依次经过组的中间件
+func (this *HttpServer) Group(prefix string, middlewares ...Middleware) *RouterGroup {
+	return newRouterGroup(this, nil, prefix, middlewares)
+}
+
+func (this *RouterGroup) Group(prefix string, middlewares ...Middleware) *RouterGroup {
+	return newRouterGroup(this.server, this, prefix, middlewares)
+}
+
+func newRouterGroup(server *HttpServer, parent *RouterGroup, prefix string, middlewares []Middleware) *RouterGroup {
+	if len(prefix) != 0 && prefix[0] != '/' {
+		panic("Router group prefix must starts with '/'!  > " + prefix)
+	}
+	if prefix = strings.TrimSuffix(prefix, "/"); parent != nil {
+		prefix = parent.prefix + prefix
+	}
+	return &RouterGroup{
+		server:      server,
+		parent:      parent,
+		prefix:      prefix,
+		middlewares: middlewares,
+	}
+}
+
+func (this *RouterGroup) Use(middlewares ...Middleware) {
+	this.server.routeLock.Lock()
+	defer this.server.routeLock.Unlock()
+	this.middlewares = append(this.middlewares, middlewares...)
+}
+
+func (this *RouterGroup) AddRouter(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.addRouter("", httpPath, handlerBuilder, name...)
+}
+func (this *RouterGroup) AddMethodRouter(method, httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.addRouter(strings.ToUpper(method), httpPath, handlerBuilder, name...)
+}
+func (this *RouterGroup) Get(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.addRouter(http.MethodGet, httpPath, handlerBuilder, name...)
+}
+func (this *RouterGroup) Post(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.addRouter(http.MethodPost, httpPath, handlerBuilder, name...)
+}
+func (this *RouterGroup) Put(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.addRouter(http.MethodPut, httpPath, handlerBuilder, name...)
+}
+func (this *RouterGroup) Delete(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.addRouter(http.MethodDelete, httpPath, handlerBuilder, name...)
+}
+func (this *RouterGroup) Patch(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.addRouter(http.MethodPatch, httpPath, handlerBuilder, name...)
+}
+
+func (this *RouterGroup) addRouter(method, httpPath string, handlerBuilder func() IHandler, names ...string) {
+	if handlerBuilder == nil {
+		panic(fmt.Sprintf("Http handler of %v is nil! ", this.prefix+httpPath))
+	}
+	if httpPath == "/" {
+		httpPath = ""
+	}
+	if fullPath := this.prefix + httpPath; len(fullPath) == 0 {
+		httpPath = "/"
+	} else {
+		httpPath = fullPath
+	}
+	this.server.addRouter(method, httpPath, func() IHandler {
+		handler := handlerBuilder()
+		handler.setGroup(this)
+		return handler
+	}, names...)
+}
+
+// 从外到内: server 的中间件，再到最外层的组直到当前组
+func (this *HttpServer) chain(group *RouterGroup) []Middleware {
+	this.routeLock.RLock()
+	defer this.routeLock.RUnlock()
+	groups := []*RouterGroup{}
+	for g := group; g != nil; g = g.parent {
+		groups = append(groups, g)
+	}
+	middlewares := append([]Middleware{}, this.middlewares...)
+	for i := len(groups) - 1; i > -1; i-- {
+		middlewares = append(middlewares, groups[i].middlewares...)
+	}
+	return middlewares
+}
+
+// 依次执行中间件以及最后的 handle
+// 每一层都会拦截 StopRun() 和 panic，外层的中间件在 next() 返回后依旧可以拿到结果
+func (this *HttpServer) runMiddlewares(handler IHandler, middlewares []Middleware, handle func()) {
+	var next func(int)
+	next = func(i int) {
+		defer recoverHandler(this, handler)
+		if handler.isOver() {
+			return
+		}
+		if i < len(middlewares) {
+			middlewares[i](handler, func() { next(i + 1) })
+		} else {
+			handle()
+		}
+	}
+	next(0)
+}
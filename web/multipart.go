@@ -0,0 +1,201 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+)
+
+var (
+	// 单个上传文件超过这个大小时写入临时文件，这时 MultipartFileData.Content 为nil
+	// 需要旧行为(全部在内存中)时调大这个值
+	MultipartMemorySize int64 = 1024 * 1024 * 4 // 4m
+	// 请求体的最大大小，超出时回复 413，<1 时不限制
+	MaxUploadSize int64 = 0
+	// 临时文件所在的目录，为空时使用系统默认的
+	MultipartTempDir = ""
+
+	Err_RequestTooLarge = errors.New("Request body too large")
+	err_NoBoundary      = errors.New("No multipart boundary")
+)
+
+// 上传的文件
+//
+// 注意：超过 MultipartMemorySize 的文件不在内存中，Content 为nil，
+// 不要直接读 Content，用 Open()/Bytes() 读取，用 Size 判断是否为空
+type MultipartFileData struct {
+	Content     []byte // 超过 MultipartMemorySize 时为nil，通过 Open() 或 Bytes() 读取
+	FileName    string
+	ContentType string
+	Size        int64
+	Header      textproto.MIMEHeader
+
+	tempFile string
+}
+
+type nopSeekCloser struct {
+	*bytes.Reader
+}
+
+func (nopSeekCloser) Close() error { return nil }
+
+// 打开文件内容，用完需要 Close()
+func (this *MultipartFileData) Open() (io.ReadSeekCloser, error) {
+	if len(this.tempFile) == 0 {
+		return nopSeekCloser{bytes.NewReader(this.Content)}, nil
+	}
+	return os.Open(this.tempFile)
+}
+
+// 读取全部内容，文件在临时目录时会整个读进内存
+func (this *MultipartFileData) Bytes() ([]byte, error) {
+	if len(this.tempFile) == 0 {
+		return this.Content, nil
+	}
+	return ioutil.ReadFile(this.tempFile)
+}
+
+// 内容在临时文件时返回文件路径，请求结束后会被删除
+func (this *MultipartFileData) TempFile() string {
+	return this.tempFile
+}
+
+// 限制这次请求的请求体大小，需要在读取参数之前调用，一般放在 Prepare() 中
+func (this *Handler) SetMaxUploadSize(size int64) {
+	this.maxUploadSize = &size
+}
+
+func (this *Handler) uploadLimit() int64 {
+	if this.maxUploadSize != nil {
+		return *this.maxUploadSize
+	}
+	return MaxUploadSize
+}
+
+// 按照限制包装请求体，Content-Length 已经超出时直接 413
+func (this *Handler) limitedBody() io.Reader {
+	limit := this.uploadLimit()
+	if limit < 1 {
+		return this.Request.Body
+	}
+	if this.Request.ContentLength > limit {
+		this.abortTooLarge()
+	}
+	return http.MaxBytesReader(this.Writer, this.Request.Body, limit)
+}
+
+func (this *Handler) abortTooLarge() {
+	this.Fail(Err_RequestTooLarge)
+}
+
+func isTooLarge(e error) bool {
+	var maxErr *http.MaxBytesError
+	return errors.As(e, &maxErr)
+}
+
+// 流式解析 multipart/form-data，文件较大时写入临时文件
+// 超出上传限制时回复 413 并结束 handler
+func (this *Handler) parseMultipart(cType string) (values url.Values, files map[string][]*MultipartFileData, e error) {
+	values, files = url.Values{}, map[string][]*MultipartFileData{}
+
+	var params map[string]string
+	if _, params, e = mime.ParseMediaType(cType); e != nil {
+		return
+	}
+	boundary := params["boundary"]
+	if len(boundary) == 0 {
+		e = err_NoBoundary
+		return
+	}
+
+	reader := multipart.NewReader(this.limitedBody(), boundary)
+	var part *multipart.Part
+	for {
+		if part, e = reader.NextPart(); e != nil {
+			if e == io.EOF {
+				e = nil
+			}
+			break
+		}
+		name := part.FormName()
+		if len(name) == 0 {
+			part.Close()
+			continue
+		}
+		_, disposition, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if _, isFile := disposition["filename"]; isFile {
+			var f *MultipartFileData
+			if f, e = this.readFilePart(part); e == nil {
+				files[name] = append(files[name], f)
+			}
+		} else {
+			var value []byte
+			if value, e = ioutil.ReadAll(io.LimitReader(part, MultipartMemorySize+1)); e == nil {
+				if int64(len(value)) > MultipartMemorySize {
+					e = errors.New("Multipart value of '" + name + "' is too large")
+				} else {
+					values[name] = append(values[name], string(value))
+				}
+			}
+		}
+		part.Close()
+		if e != nil {
+			break
+		}
+	}
+	if isTooLarge(e) {
+		this.release()
+		this.abortTooLarge()
+	}
+	return
+}
+
+func (this *Handler) readFilePart(part *multipart.Part) (f *MultipartFileData, e error) {
+	f = &MultipartFileData{
+		FileName:    part.FileName(),
+		ContentType: part.Header.Get("Content-Type"),
+		Header:      part.Header,
+	}
+	buff := bytes.NewBuffer([]byte{})
+	if f.Size, e = io.Copy(buff, io.LimitReader(part, MultipartMemorySize+1)); e != nil {
+		return
+	}
+	if f.Size <= MultipartMemorySize {
+		f.Content = buff.Bytes()
+		return
+	}
+
+	// 超出内存阈值，写入临时文件
+	var tmp *os.File
+	if tmp, e = ioutil.TempFile(MultipartTempDir, "upload-"); e != nil {
+		return
+	}
+	defer tmp.Close()
+	f.tempFile = tmp.Name()
+	this.tempFiles = append(this.tempFiles, f.tempFile)
+	if _, e = tmp.Write(buff.Bytes()); e == nil {
+		var n int64
+		n, e = io.Copy(tmp, part)
+		f.Size += n
+	}
+	return
+}
+
+// 请求结束后清理临时文件，关闭 SSE，之后不能再写入 writer
+func (this *Handler) release() {
+	for _, stream := range this.streams {
+		stream.Close()
+	}
+	this.streams = nil
+	for _, f := range this.tempFiles {
+		os.Remove(f)
+	}
+	this.tempFiles = nil
+}
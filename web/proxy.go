@@ -0,0 +1,207 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	// 本机和内网地址，反向代理和服务在同一个内网时可以直接使用
+	//
+	//	web.SetTrustedProxies(web.PrivateNetworks...)
+	PrivateNetworks = []string{
+		"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16",
+		"::1/128", "fc00::/7",
+	}
+)
+
+// 设置可信的反向代理，支持 CIDR、单个 IP 和 "unix"(通过 unix socket 连接的代理)
+// 只有直接连接的地址在其中时，才会读取 HttpServer.ProxyHeader 指定的请求头
+func SetTrustedProxies(cidrs ...string) error {
+	return DefaultServer.SetTrustedProxies(cidrs...)
+}
+
+func (this *HttpServer) SetTrustedProxies(cidrs ...string) error {
+	nets, trustUnix := make([]*net.IPNet, 0, len(cidrs)), false
+	for _, cidr := range cidrs {
+		if cidr == "unix" {
+			trustUnix = true
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, ipNet, e := net.ParseCIDR(cidr)
+		if e != nil {
+			return e
+		}
+		nets = append(nets, ipNet)
+	}
+	this.routeLock.Lock()
+	defer this.routeLock.Unlock()
+	this.trustedProxies, this.trustUnix = nets, trustUnix
+	return nil
+}
+
+// 直接连接的一方，unix socket 上的连接没有 IP
+func (this *HttpServer) isTrustedPeer(request *http.Request, remote net.IP) bool {
+	if addr, _ := request.Context().Value(http.LocalAddrContextKey).(net.Addr); addr != nil && addr.Network() == "unix" {
+		this.routeLock.RLock()
+		defer this.routeLock.RUnlock()
+		return this.trustUnix
+	}
+	return this.isTrusted(remote)
+}
+
+func (this *HttpServer) isTrusted(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	this.routeLock.RLock()
+	defer this.routeLock.RUnlock()
+	for _, ipNet := range this.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// 去掉端口、IPv6 的方括号和 zone，不是合法 IP 时返回nil
+func parseHostIP(addr string) (string, net.IP) {
+	addr = strings.TrimSpace(addr)
+	if host, _, e := net.SplitHostPort(addr); e == nil {
+		addr = host
+	} else {
+		addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	}
+	if i := strings.IndexByte(addr, '%'); i != -1 {
+		addr = addr[:i]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return addr, nil
+	}
+	return ip.String(), ip
+}
+
+// Forwarded 中的一个节点
+type forwardedHop struct {
+	addr, proto, host string
+}
+
+// RFC 7239，例如 for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8::1]:4711"
+func parseForwarded(values []string) (hops []forwardedHop) {
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			hop := forwardedHop{}
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				v := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+				switch strings.ToLower(kv[0]) {
+				case "for":
+					hop.addr = v
+				case "proto":
+					hop.proto = strings.ToLower(v)
+				case "host":
+					hop.host = v
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return
+}
+
+func splitList(values []string) (list []string) {
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); len(item) != 0 {
+				list = append(list, item)
+			}
+		}
+	}
+	return
+}
+
+// 请求的真实来源，从右往左跳过可信代理，遇到第一个不可信或不合法的地址为止
+// 只读取 ProxyHeader，没有时不会换成其它请求头，否则客户端可以自己伪造
+func (this *HttpServer) resolveClient(request *http.Request) (ip string, hop forwardedHop) {
+	ip, remote := parseHostIP(request.RemoteAddr)
+	if !this.isTrustedPeer(request, remote) {
+		return
+	}
+	header := http.CanonicalHeaderKey(this.ProxyHeader)
+	if len(header) == 0 {
+		header = "X-Forwarded-For"
+	}
+	var hops []forwardedHop
+	switch header {
+	case "Forwarded":
+		hops = parseForwarded(request.Header.Values(header))
+	case "X-Real-Ip":
+		if realIP := strings.TrimSpace(request.Header.Get(header)); len(realIP) != 0 {
+			hops = []forwardedHop{{addr: realIP}}
+		}
+	default:
+		for _, addr := range splitList(request.Header.Values(header)) {
+			hops = append(hops, forwardedHop{addr: addr})
+		}
+	}
+	if header != "Forwarded" && len(hops) != 0 {
+		proto := splitList(request.Header.Values("X-Forwarded-Proto"))
+		host := splitList(request.Header.Values("X-Forwarded-Host"))
+		// 只信任最近一层代理设置的值
+		if len(proto) != 0 {
+			hops[len(hops)-1].proto = strings.ToLower(proto[len(proto)-1])
+		}
+		if len(host) != 0 {
+			hops[len(hops)-1].host = host[len(host)-1]
+		}
+	}
+	for i := len(hops) - 1; i > -1; i-- {
+		addr, parsed := parseHostIP(hops[i].addr)
+		if parsed == nil {
+			// unknown、_hidden 等无法继续往前追溯
+			break
+		}
+		ip = addr
+		if len(hops[i].proto) != 0 {
+			hop.proto = hops[i].proto
+		}
+		if len(hops[i].host) != 0 {
+			hop.host = hops[i].host
+		}
+		if !this.isTrusted(parsed) {
+			break
+		}
+	}
+	return
+}
+
+// 客户端看到的协议，http 或 https
+func (this *Handler) Scheme() string {
+	if _, hop := this.getServer().resolveClient(this.Request); hop.proto == "http" || hop.proto == "https" {
+		return hop.proto
+	}
+	if this.Request.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// 客户端请求的 Host，经过可信代理时使用代理转发的值
+func (this *Handler) Host() string {
+	if _, hop := this.getServer().resolveClient(this.Request); len(hop.host) != 0 {
+		return hop.host
+	}
+	return this.Request.Host
+}
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolveClient(t *testing.T) {
+	cases := []struct {
+		name        string
+		proxyHeader string
+		trusted     []string
+		remote      string
+		unix        bool
+		headers     map[string]string
+		ip          string
+		proto, host string
+	}{
+		{"direct", "", nil, "203.0.113.5:1234", false, nil, "203.0.113.5", "", ""},
+		{"untrusted peer", "", nil, "203.0.113.5:1234", false,
+			map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https"}, "203.0.113.5", "", ""},
+		{"trusted peer", "", []string{"10.0.0.0/8"}, "10.0.0.1:1234", false,
+			map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "example.com"}, "198.51.100.7", "https", "example.com"},
+		{"spoofed leftmost", "", []string{"10.0.0.0/8"}, "10.0.0.1:1234", false,
+			map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7"}, "198.51.100.7", "", ""},
+		{"trusted chain", "", []string{"10.0.0.0/8"}, "10.0.0.1:1234", false,
+			map[string]string{"X-Forwarded-For": "6.6.6.6, 198.51.100.7, 10.0.0.2"}, "198.51.100.7", "", ""},
+		{"invalid hop", "", []string{"10.0.0.0/8"}, "10.0.0.1:1234", false,
+			map[string]string{"X-Forwarded-For": "198.51.100.7, unknown"}, "10.0.0.1", "", ""},
+		{"forwarded quoted ipv6", "Forwarded", []string{"10.0.0.1"}, "10.0.0.1:1234", false,
+			map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=HTTPS;host=example.com`}, "2001:db8::1", "https", "example.com"},
+		{"forwarded spoofed", "Forwarded", []string{"10.0.0.1"}, "10.0.0.1:1234", false,
+			map[string]string{"Forwarded": "for=6.6.6.6, for=198.51.100.7"}, "198.51.100.7", "", ""},
+		{"forwarded ignores xff", "Forwarded", []string{"10.0.0.1"}, "10.0.0.1:1234", false,
+			map[string]string{"X-Forwarded-For": "198.51.100.7"}, "10.0.0.1", "", ""},
+		{"x-real-ip ignored", "", []string{"10.0.0.1"}, "10.0.0.1:1234", false,
+			map[string]string{"X-Real-IP": "6.6.6.6"}, "10.0.0.1", "", ""},
+		{"x-real-ip configured", "X-Real-IP", []string{"10.0.0.1"}, "10.0.0.1:1234", false,
+			map[string]string{"X-Real-IP": "198.51.100.7", "X-Forwarded-For": "6.6.6.6"}, "198.51.100.7", "", ""},
+		{"ipv6 peer", "", []string{"::1"}, "[::1]:1234", false,
+			map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7", "", ""},
+		{"unix trusted", "", []string{"unix"}, "@", true,
+			map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7", "", ""},
+		{"unix untrusted", "", []string{"10.0.0.0/8"}, "@", true,
+			map[string]string{"X-Forwarded-For": "198.51.100.7"}, "@", "", ""},
+		{"unix not trusted by cidr", "", []string{"0.0.0.0/0"}, "@", true,
+			map[string]string{"X-Forwarded-For": "198.51.100.7"}, "@", "", ""},
+	}
+	for _, c := range cases {
+		server := &HttpServer{ProxyHeader: c.proxyHeader}
+		if e := server.SetTrustedProxies(c.trusted...); e != nil {
+			t.Fatal(c.name, e)
+		}
+		request := httptest.NewRequest("GET", "/", nil)
+		request.RemoteAddr = c.remote
+		for k, v := range c.headers {
+			request.Header.Set(k, v)
+		}
+		if c.unix {
+			local := &net.UnixAddr{Name: "/run/web.sock", Net: "unix"}
+			request = request.WithContext(context.WithValue(request.Context(), http.LocalAddrContextKey, local))
+		}
+		ip, hop := server.resolveClient(request)
+		if ip != c.ip || hop.proto != c.proto || hop.host != c.host {
+			t.Errorf("%s: got %s %q %q, expect %s %q %q", c.name, ip, hop.proto, hop.host, c.ip, c.proto, c.host)
+		}
+	}
+}
+
+func TestSetTrustedProxies(t *testing.T) {
+	server := &HttpServer{}
+	if e := server.SetTrustedProxies("10.0.0.1", "::1", "192.168.0.0/16", "unix"); e != nil {
+		t.Fatal(e)
+	}
+	for ip, trusted := range map[string]bool{"10.0.0.1": true, "10.0.0.2": false, "::1": true, "192.168.3.4": true, "8.8.8.8": false} {
+		if server.isTrusted(net.ParseIP(ip)) != trusted {
+			t.Errorf("%s: expect %v", ip, trusted)
+		}
+	}
+	if !server.trustUnix {
+		t.Error("unix not trusted")
+	}
+	if e := server.SetTrustedProxies("10.0.0.0/33"); e == nil {
+		t.Error("expect an error for a bad CIDR")
+	}
+}
@@ -0,0 +1,210 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// 一次请求最多允许的区间数，超出时忽略 Range 返回整个文件
+	MaxRanges = 16
+
+	err_InvalidRange   = errors.New("Invalid range")
+	err_NoOverlapRange = errors.New("Range not satisfiable")
+)
+
+// RFC 7233 的一个区间
+type byteRange struct {
+	start, length int64
+}
+
+func (this byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", this.start, this.start+this.length-1, size)
+}
+
+// 解析 Range 头，格式不对返回 err_InvalidRange(应当忽略)，
+// 所有区间都超出文件时返回 err_NoOverlapRange(应当回复 416)，重叠或相邻的区间会合并
+func parseRange(s string, size int64) (ranges []byteRange, e error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(s, prefix) {
+		return nil, err_InvalidRange
+	}
+	noOverlap := false
+	for _, ra := range strings.Split(s[len(prefix):], ",") {
+		ra = strings.TrimSpace(ra)
+		if len(ra) == 0 {
+			continue
+		}
+		ind := strings.Index(ra, "-")
+		if ind < 0 {
+			return nil, err_InvalidRange
+		}
+		start, end := strings.TrimSpace(ra[:ind]), strings.TrimSpace(ra[ind+1:])
+		var r byteRange
+		if len(start) == 0 {
+			// bytes=-500 表示最后 500 个字节
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return nil, err_InvalidRange
+			}
+			if n == 0 {
+				noOverlap = true
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r.start, r.length = size-n, n
+		} else {
+			i, err := strconv.ParseInt(start, 10, 64)
+			if err != nil || i < 0 {
+				return nil, err_InvalidRange
+			}
+			if i >= size {
+				noOverlap = true
+				continue
+			}
+			r.start = i
+			if len(end) == 0 {
+				r.length = size - i
+			} else {
+				j, err := strconv.ParseInt(end, 10, 64)
+				if err != nil || j < i {
+					return nil, err_InvalidRange
+				}
+				if j >= size {
+					j = size - 1
+				}
+				r.length = j - i + 1
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	if len(ranges) == 0 {
+		if noOverlap {
+			return nil, err_NoOverlapRange
+		}
+		return nil, err_InvalidRange
+	}
+	return coalesceRanges(ranges), nil
+}
+
+// 没有重叠时保持客户端的顺序，否则按起点排序后合并
+func coalesceRanges(ranges []byteRange) []byteRange {
+	sorted := append([]byteRange{}, ranges...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+	merged := sorted[:1]
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.start > last.start+last.length {
+			merged = append(merged, r)
+		} else if end := r.start + r.length; end > last.start+last.length {
+			last.length = end - last.start
+		}
+	}
+	if len(merged) == len(ranges) {
+		return ranges
+	}
+	return merged
+}
+
+// If-Range 匹配时才使用 Range，只接受强 ETag 或者精确到秒的 Last-Modified
+func checkIfRange(ifRange, etag string, modTime time.Time) bool {
+	if len(ifRange) == 0 {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		return ifRange == etag
+	}
+	t, e := http.ParseTime(ifRange)
+	return e == nil && modTime.Unix() == t.Unix()
+}
+
+// Close() 时关闭文件的 SectionReader
+type sectionReadCloser struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (this *sectionReadCloser) Close() error {
+	return this.file.Close()
+}
+
+func rangePartHeader(r byteRange, contentType string, size int64) textproto.MIMEHeader {
+	header := textproto.MIMEHeader{"Content-Range": {r.contentRange(size)}}
+	if len(contentType) != 0 {
+		header.Set("Content-Type", contentType)
+	}
+	return header
+}
+
+type countWriter int64
+
+func (this *countWriter) Write(bs []byte) (int, error) {
+	*this += countWriter(len(bs))
+	return len(bs), nil
+}
+
+// 按区间回复 206，单个区间直接从文件流式输出，多个区间输出 multipart/byteranges
+func (this *Handler) responseRanges(file *os.File, ranges []byteRange, contentType string, size int64) {
+	if len(ranges) == 1 {
+		r := ranges[0]
+		this.ResponseHeader("Content-Range", r.contentRange(size))
+		this.ResponseHeader("Content-Length", strconv.FormatInt(r.length, 10))
+		this.ResponseData(&Stream{Reader: &sectionReadCloser{io.NewSectionReader(file, r.start, r.length), file}})
+		this.ResponseStatus(http.StatusPartialContent)
+		return
+	}
+
+	// 先用同样的 boundary 算出总长度
+	counter := new(countWriter)
+	mw := multipart.NewWriter(counter)
+	for _, r := range ranges {
+		mw.CreatePart(rangePartHeader(r, contentType, size))
+		*counter += countWriter(r.length)
+	}
+	mw.Close()
+
+	reader, writer := io.Pipe()
+	pw := multipart.NewWriter(writer)
+	pw.SetBoundary(mw.Boundary())
+	go func() {
+		defer file.Close()
+		var e error
+		for _, r := range ranges {
+			var part io.Writer
+			if part, e = pw.CreatePart(rangePartHeader(r, contentType, size)); e != nil {
+				break
+			}
+			if _, e = io.Copy(part, io.NewSectionReader(file, r.start, r.length)); e != nil {
+				break
+			}
+		}
+		if e == nil {
+			e = pw.Close()
+		}
+		writer.CloseWithError(e)
+	}()
+
+	delete(this.ResHeaders, "Content-Type")
+	this.ResponseHeader("Content-Type", "multipart/byteranges; boundary="+pw.Boundary())
+	this.ResponseHeader("Content-Length", strconv.FormatInt(int64(*counter), 10))
+	this.ResponseData(&Stream{Reader: reader})
+	this.ResponseStatus(http.StatusPartialContent)
+}
+
+// 区间无法满足时回复 416
+func (this *Handler) responseRangeNotSatisfiable(size int64) {
+	delete(this.ResHeaders, "Content-Type")
+	this.ResponseHeader("Content-Range", "bytes */"+strconv.FormatInt(size, 10))
+	this.Fail(&HTTPError{Code: http.StatusRequestedRangeNotSatisfiable})
+}
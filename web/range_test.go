@@ -0,0 +1,143 @@
+package web
+
+import (
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseRange(t *testing.T) {
+	cases := []struct {
+		header string
+		ranges []byteRange
+		err    error
+	}{
+		{"bytes=0-0", []byteRange{{0, 1}}, nil},
+		{"bytes=0-4", []byteRange{{0, 5}}, nil},
+		{"bytes=5-", []byteRange{{5, 5}}, nil},
+		{"bytes=-3", []byteRange{{7, 3}}, nil},
+		{"bytes=-20", []byteRange{{0, 10}}, nil},
+		{"bytes=8-20", []byteRange{{8, 2}}, nil},
+		{"bytes= 1-2 , 6-7", []byteRange{{1, 2}, {6, 2}}, nil},
+		{"bytes=6-7,1-2", []byteRange{{6, 2}, {1, 2}}, nil},
+		{"bytes=0-4,2-6", []byteRange{{0, 7}}, nil},
+		{"bytes=0-1,2-3", []byteRange{{0, 4}}, nil},
+		{"bytes=6-7,0-1,1-3", []byteRange{{0, 4}, {6, 2}}, nil},
+		{"bytes=2-3,0-9", []byteRange{{0, 10}}, nil},
+		{"bytes=10-,3-4", []byteRange{{3, 2}}, nil},
+		{"bytes=10-", nil, err_NoOverlapRange},
+		{"bytes=-0", nil, err_NoOverlapRange},
+		{"bytes=4-2", nil, err_InvalidRange},
+		{"bytes=a-b", nil, err_InvalidRange},
+		{"bytes=1", nil, err_InvalidRange},
+		{"bytes=", nil, err_InvalidRange},
+		{"items=0-1", nil, err_InvalidRange},
+	}
+	for _, c := range cases {
+		ranges, e := parseRange(c.header, 10)
+		if e != c.err || !reflect.DeepEqual(ranges, c.ranges) {
+			t.Errorf("%q: got %v %v, expect %v %v", c.header, ranges, e, c.ranges, c.err)
+		}
+	}
+}
+
+func TestCheckIfRange(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	etag := `"5e0d5c35-a"`
+	cases := []struct {
+		ifRange string
+		match   bool
+	}{
+		{"", true},
+		{etag, true},
+		{`"other"`, false},
+		{"W/" + etag, false},
+		{modTime.Format(http.TimeFormat), true},
+		{modTime.Add(time.Second).Format(http.TimeFormat), false},
+		{"yesterday", false},
+	}
+	for _, c := range cases {
+		if got := checkIfRange(c.ifRange, etag, modTime.Add(time.Millisecond*300)); got != c.match {
+			t.Errorf("%q: got %v", c.ifRange, got)
+		}
+	}
+}
+
+func TestStaticRanges(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	if e := os.WriteFile(file, []byte("0123456789"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	info, _ := os.Stat(file)
+	server := &HttpServer{}
+	server.AddGZipStaticRouter("/static", dir, true)
+	get := func(headers ...string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "/static/a.txt", nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			request.Header.Set(headers[i], headers[i+1])
+		}
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, request)
+		return rec
+	}
+
+	rec := get("Range", "bytes=0-0")
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "0" || rec.Header().Get("Content-Range") != "bytes 0-0/10" {
+		t.Fatal(rec.Code, rec.Body.String(), rec.Header())
+	}
+
+	rec = get("Range", "bytes=1-2,6-")
+	mediaType, params, _ := mime.ParseMediaType(rec.Header().Get("Content-Type"))
+	if rec.Code != http.StatusPartialContent || mediaType != "multipart/byteranges" {
+		t.Fatal(rec.Code, rec.Header())
+	}
+	if rec.Header().Get("Content-Length") != strconv.Itoa(rec.Body.Len()) {
+		t.Fatal("wrong Content-Length", rec.Header().Get("Content-Length"), rec.Body.Len())
+	}
+	reader := multipart.NewReader(rec.Body, params["boundary"])
+	for _, expect := range []struct{ contentRange, body string }{{"bytes 1-2/10", "12"}, {"bytes 6-9/10", "6789"}} {
+		part, e := reader.NextPart()
+		if e != nil {
+			t.Fatal(e)
+		}
+		bs, _ := io.ReadAll(part)
+		if part.Header.Get("Content-Range") != expect.contentRange || string(bs) != expect.body {
+			t.Fatal(part.Header, string(bs))
+		}
+	}
+	if _, e := reader.NextPart(); e != io.EOF {
+		t.Fatal("too many parts", e)
+	}
+
+	etag := rec.Header().Get("ETag")
+	if len(etag) == 0 {
+		t.Fatal("no ETag")
+	}
+	if rec = get("Range", "bytes=2-3", "If-Range", etag); rec.Body.String() != "23" {
+		t.Fatal("If-Range etag", rec.Code, rec.Body.String())
+	}
+	if rec = get("Range", "bytes=2-3", "If-Range", `"stale"`); rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Fatal("stale If-Range", rec.Code, rec.Body.String())
+	}
+	if rec = get("Range", "bytes=2-3", "If-Range", info.ModTime().UTC().Format(http.TimeFormat)); rec.Body.String() != "23" {
+		t.Fatal("If-Range date", rec.Code, rec.Body.String())
+	}
+	if rec = get("Range", "bytes=2-3", "If-Range", info.ModTime().Add(-time.Hour).UTC().Format(http.TimeFormat)); rec.Code != http.StatusOK {
+		t.Fatal("old If-Range date", rec.Code)
+	}
+	if rec = get("Range", "bytes=20-"); rec.Code != http.StatusRequestedRangeNotSatisfiable || rec.Header().Get("Content-Range") != "bytes */10" {
+		t.Fatal(rec.Code, rec.Header())
+	}
+	if rec = get("Range", "bytes=0-3,2-9"); rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Fatal("coalesced to the whole file", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,205 @@
+package web
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"zwei.ren/memory/weakmap"
+)
+
+const (
+	TokenBucket = iota
+	SlidingWindow
+)
+
+var (
+	// 每个限流器最多记录的 key，超出时丢弃最久没有访问的
+	RateLimitMaxKeys = 10000
+)
+
+// 从请求中取限流的 key，返回空时不限流
+type RateLimitKey func(handler IHandler) string
+
+func KeyByIP(handler IHandler) string {
+	return handler.IP()
+}
+
+func KeyByHeader(name string) RateLimitKey {
+	return func(handler IHandler) string {
+		request, _ := handler.GetIO()
+		return request.Header.Get(name)
+	}
+}
+
+// 没有这个 cookie 时按 IP
+func KeyByCookie(name string) RateLimitKey {
+	return func(handler IHandler) string {
+		request, _ := handler.GetIO()
+		if cookie, e := request.Cookie(name); e == nil && len(cookie.Value) != 0 {
+			return name + "=" + cookie.Value
+		}
+		return handler.IP()
+	}
+}
+
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // 额度完全恢复(令牌桶)或当前窗口结束(滑动窗口)的时间
+	RetryAfter time.Duration // 被拒绝时，多久之后可以重试
+}
+
+// 限流器，Window 时间内最多 Limit 个请求
+//
+//	limit := web.NewTokenBucket(10, time.Minute, nil)
+//	server.Use(limit.Middleware())                                      // 全局
+//	server.Group("/login", limit.Middleware()).Post("/", loginBuilder) // 单个路由
+type RateLimit struct {
+	Algorithm int
+	Limit     int
+	Window    time.Duration
+	// 为nil时按 IP
+	Key RateLimitKey
+
+	lock  sync.Mutex
+	store weakmap.Map
+	clock func() time.Time // 为nil时使用 time.Now，测试时替换
+}
+
+// 令牌桶：容量为 limit，每 window 补满，允许短时间的突发
+func NewTokenBucket(limit int, window time.Duration, key RateLimitKey) *RateLimit {
+	return &RateLimit{Algorithm: TokenBucket, Limit: limit, Window: window, Key: key}
+}
+
+// 滑动窗口：按上一个窗口的计数加权估算，任意 window 时间内约 limit 个请求
+func NewSlidingWindow(limit int, window time.Duration, key RateLimitKey) *RateLimit {
+	return &RateLimit{Algorithm: SlidingWindow, Limit: limit, Window: window, Key: key}
+}
+
+type bucketState struct {
+	tokens float64
+	last   time.Time
+}
+
+type windowState struct {
+	start      time.Time
+	prev, curr int
+}
+
+// 消耗 key 的一次额度
+func (this *RateLimit) Allow(key string) RateLimitResult {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.store == nil {
+		this.store = weakmap.NewWeakMap(RateLimitMaxKeys)
+	}
+	now := time.Now()
+	if this.clock != nil {
+		now = this.clock()
+	}
+	if this.Algorithm == SlidingWindow {
+		return this.allowWindow(key, now)
+	}
+	return this.allowBucket(key, now)
+}
+
+func (this *RateLimit) allowBucket(key string, now time.Time) (res RateLimitResult) {
+	res.Limit = this.Limit
+	limit := float64(this.Limit)
+	perSecond := limit / this.Window.Seconds()
+	state, _ := this.store.Load(key)
+	bucket, _ := state.(*bucketState)
+	if bucket == nil {
+		bucket = &bucketState{tokens: limit, last: now}
+		this.store.Store(key, bucket)
+	} else {
+		bucket.tokens = math.Min(limit, bucket.tokens+now.Sub(bucket.last).Seconds()*perSecond)
+		bucket.last = now
+	}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - bucket.tokens) / perSecond * float64(time.Second))
+	}
+	res.Remaining = int(bucket.tokens)
+	res.Reset = time.Duration((limit - bucket.tokens) / perSecond * float64(time.Second))
+	return
+}
+
+func (this *RateLimit) allowWindow(key string, now time.Time) (res RateLimitResult) {
+	res.Limit = this.Limit
+	state, _ := this.store.Load(key)
+	window, _ := state.(*windowState)
+	if window == nil {
+		window = &windowState{start: now.Truncate(this.Window)}
+		this.store.Store(key, window)
+	}
+	if elapsed := now.Sub(window.start); elapsed >= this.Window*2 {
+		window.start, window.prev, window.curr = now.Truncate(this.Window), 0, 0
+	} else if elapsed >= this.Window {
+		window.start, window.prev, window.curr = window.start.Add(this.Window), window.curr, 0
+	}
+	elapsed := now.Sub(window.start)
+	weight := 1 - float64(elapsed)/float64(this.Window)
+	estimate := float64(window.prev)*weight + float64(window.curr)
+	res.Reset = this.Window - elapsed
+	if estimate+1 <= float64(this.Limit) {
+		window.curr++
+		estimate++
+		res.Allowed = true
+	} else if window.prev != 0 && window.curr < this.Limit {
+		// 上一个窗口的权重降到能再放行一个请求的时间
+		need := 1 - float64(this.Limit-1-window.curr)/float64(window.prev)
+		res.RetryAfter = time.Duration(need*float64(this.Window)) - elapsed
+	} else {
+		res.RetryAfter = res.Reset
+	}
+	if res.Remaining = this.Limit - int(math.Ceil(estimate)); res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	return
+}
+
+// 设置 RateLimit-* 响应头，超出时回复 429 并带上 Retry-After
+func (this *RateLimit) Middleware() Middleware {
+	return func(handler IHandler, next func()) {
+		keyFunc := this.Key
+		if keyFunc == nil {
+			keyFunc = KeyByIP
+		}
+		key := keyFunc(handler)
+		if len(key) == 0 {
+			next()
+			return
+		}
+		res := this.Allow(key)
+		// 直接写在 writer 上，handler 调用 ResponseHeaders() 替换响应头时也不会丢
+		_, writer := handler.GetIO()
+		header := writer.Header()
+		header.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		header.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		header.Set("RateLimit-Policy", strconv.Itoa(this.Limit)+";w="+strconv.Itoa(ceilSeconds(this.Window)))
+		if !res.Allowed {
+			retry := ceilSeconds(res.RetryAfter)
+			if retry < 1 {
+				retry = 1
+			}
+			header.Set("Retry-After", strconv.Itoa(retry))
+			panic(&HTTPError{Code: http.StatusTooManyRequests})
+		}
+		next()
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
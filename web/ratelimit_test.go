@@ -0,0 +1,116 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type rateStep struct {
+	at         time.Duration // 相对开始的时间
+	allowed    bool
+	remaining  int
+	reset      time.Duration
+	retryAfter time.Duration
+}
+
+func runRateSteps(t *testing.T, name string, limit *RateLimit, steps []rateStep) {
+	start := time.Unix(1000, 0)
+	var now time.Time
+	limit.clock = func() time.Time { return now }
+	for i, step := range steps {
+		now = start.Add(step.at)
+		res := limit.Allow("k")
+		expect := RateLimitResult{Allowed: step.allowed, Limit: limit.Limit, Remaining: step.remaining, Reset: step.reset, RetryAfter: step.retryAfter}
+		if res != expect {
+			t.Errorf("%s step %d at %v: got %+v, expect %+v", name, i, step.at, res, expect)
+		}
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	// 容量 3，每秒补充 1 个
+	runRateSteps(t, "bucket", NewTokenBucket(3, 3*time.Second, nil), []rateStep{
+		{0, true, 2, time.Second, 0},
+		{0, true, 1, 2 * time.Second, 0},
+		{0, true, 0, 3 * time.Second, 0},
+		{0, false, 0, 3 * time.Second, time.Second},
+		{500 * time.Millisecond, false, 0, 2500 * time.Millisecond, 500 * time.Millisecond},
+		{time.Second, true, 0, 3 * time.Second, 0},
+		// 最多补满到容量
+		{11 * time.Second, true, 2, time.Second, 0},
+	})
+}
+
+func TestSlidingWindow(t *testing.T) {
+	// 每 10 秒 2 个
+	runRateSteps(t, "window", NewSlidingWindow(2, 10*time.Second, nil), []rateStep{
+		{0, true, 1, 10 * time.Second, 0},
+		{time.Second, true, 0, 9 * time.Second, 0},
+		// 上一个窗口为空，等到窗口结束
+		{2 * time.Second, false, 0, 8 * time.Second, 8 * time.Second},
+		// 上一个窗口的 2 个权重为 0.8，降到 0.5 时可以再放行一个
+		{12 * time.Second, false, 0, 8 * time.Second, 3 * time.Second},
+		{15 * time.Second, true, 0, 5 * time.Second, 0},
+		{16 * time.Second, false, 0, 4 * time.Second, 4 * time.Second},
+		{20 * time.Second, true, 0, 10 * time.Second, 0},
+		// 超过两个窗口没有请求，重新开始
+		{45 * time.Second, true, 1, 5 * time.Second, 0},
+	})
+}
+
+type replaceHeadersHandler struct {
+	Handler
+}
+
+func (this *replaceHeadersHandler) Handle() {
+	this.ResponseHeaders(map[string][]string{"X-Custom": {"1"}})
+	this.ResponseOK()
+	this.ResponseData("ok")
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	limit := NewTokenBucket(1, time.Minute, KeyByHeader("X-Client"))
+	server := &HttpServer{}
+	server.Group("/limited", limit.Middleware()).AddRouter("/", func() IHandler { return new(replaceHeadersHandler) })
+	get := func(client string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("GET", "/limited/", nil)
+		request.Header.Set("X-Client", client)
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, request)
+		return rec
+	}
+
+	// ResponseHeaders() 替换响应头之后 RateLimit-* 仍然存在
+	rec := get("a")
+	expect := map[string]string{
+		"X-Custom":            "1",
+		"RateLimit-Limit":     "1",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "60",
+		"RateLimit-Policy":    "1;w=60",
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatal(rec.Code, rec.Body.String())
+	}
+	for k, v := range expect {
+		if got := rec.Header().Get(k); got != v {
+			t.Errorf("%s: got %q, expect %q", k, got, v)
+		}
+	}
+
+	if rec = get("a"); rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Error(rec.Code, rec.Header())
+	}
+	// 不同的 key 分别计数
+	if rec = get("b"); rec.Code != http.StatusOK {
+		t.Error(rec.Code)
+	}
+	// 没有 key 时不限流
+	for i := 0; i < 3; i++ {
+		if rec = get(""); rec.Code != http.StatusOK || len(rec.Header().Get("RateLimit-Limit")) != 0 {
+			t.Error(rec.Code, rec.Header())
+		}
+	}
+}
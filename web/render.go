@@ -0,0 +1,396 @@
+package web
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	// 编码器不支持这个类型时返回，协商会继续尝试下一个
+	Err_Unencodable   = errors.New("Value cannot be encoded in this format")
+	Err_NotAcceptable = errors.New("No encoder matches the Accept header")
+
+	encoderLock sync.RWMutex
+	encoders    = []*Encoder{}
+	decoders    = map[string]func([]byte) (map[string]interface{}, error){}
+)
+
+// 响应编码器，Name 给 Render() 用，ContentTypes 参与 Accept 协商，第一个作为返回的 Content-Type
+type Encoder struct {
+	Name         string
+	ContentTypes []string
+	Encode       func(v interface{}) ([]byte, error)
+}
+
+func init() {
+	RegisterEncoder("json", []string{"application/json"}, json.Marshal)
+	RegisterEncoder("xml", []string{"application/xml", "text/xml"}, encodeXML)
+	RegisterEncoder("msgpack", []string{"application/msgpack", "application/x-msgpack"}, encodeMsgpack)
+	RegisterEncoder("protobuf", []string{"application/x-protobuf", "application/protobuf"}, encodeProtobuf)
+	RegisterEncoder("text", []string{"text/plain; charset=utf-8"}, encodeText)
+	RegisterEncoder("csv", []string{"text/csv; charset=utf-8"}, encodeCSV)
+
+	RegisterDecoder("application/json", decodeJSON)
+	RegisterDecoder("application/xml", decodeXML)
+	RegisterDecoder("text/xml", decodeXML)
+}
+
+// 注册或者替换同名的编码器，第一个注册的(json)是没有 Accept 时的默认格式
+// 例如接入 protobuf 的官方库:
+//
+//	web.RegisterEncoder("protobuf", []string{"application/x-protobuf"}, func(v interface{}) ([]byte, error) {
+//		if m, is := v.(proto.Message); is {
+//			return proto.Marshal(m)
+//		}
+//		return nil, web.Err_Unencodable
+//	})
+func RegisterEncoder(name string, contentTypes []string, encode func(v interface{}) ([]byte, error)) {
+	if len(name) == 0 || len(contentTypes) == 0 || encode == nil {
+		panic("Wrong encoder: " + name)
+	}
+	encoderLock.Lock()
+	defer encoderLock.Unlock()
+	encoder := &Encoder{Name: name, ContentTypes: contentTypes, Encode: encode}
+	for i, e := range encoders {
+		if e.Name == name {
+			encoders[i] = encoder
+			return
+		}
+	}
+	encoders = append(encoders, encoder)
+}
+
+// 注册请求体的解码器，GetPostParams() 按 Content-Type 调用
+func RegisterDecoder(contentType string, decode func([]byte) (map[string]interface{}, error)) {
+	encoderLock.Lock()
+	defer encoderLock.Unlock()
+	decoders[strings.ToLower(contentType)] = decode
+}
+
+func getDecoder(mediaType string) func([]byte) (map[string]interface{}, error) {
+	encoderLock.RLock()
+	defer encoderLock.RUnlock()
+	return decoders[mediaType]
+}
+
+func getEncoder(name string) *Encoder {
+	encoderLock.RLock()
+	defer encoderLock.RUnlock()
+	for _, e := range encoders {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+// 解析 Accept，按 q 从大到小排序，q 相同时越具体越靠前
+func parseAccept(accept string) []acceptRange {
+	ranges := []acceptRange{}
+	for _, item := range strings.Split(accept, ",") {
+		fields := strings.Split(item, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(mediaType) == 0 {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			if kv := strings.SplitN(strings.TrimSpace(param), "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == "q" {
+				if f, e := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); e == nil {
+					q = f
+				}
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, acceptRange{mediaType, q})
+		}
+	}
+	specificity := func(t string) int {
+		if t == "*/*" {
+			return 0
+		} else if strings.HasSuffix(t, "/*") {
+			return 1
+		}
+		return 2
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return specificity(ranges[i].mediaType) > specificity(ranges[j].mediaType)
+	})
+	return ranges
+}
+
+func mediaTypeMatch(pattern, contentType string) bool {
+	if ind := strings.Index(contentType, ";"); ind != -1 {
+		contentType = contentType[:ind]
+	}
+	contentType = strings.TrimSpace(contentType)
+	if pattern == "*/*" || pattern == contentType {
+		return true
+	}
+	return strings.HasSuffix(pattern, "/*") && strings.HasPrefix(contentType, pattern[:len(pattern)-1])
+}
+
+// 按 Accept 选择编码器，返回编码后的内容和 Content-Type
+// 没有 Accept 或者是浏览器直接打开(Accept 含 text/html)时使用第一个编码器
+// 都不能编码时返回 Err_NotAcceptable
+func negotiate(accept string, v interface{}) (bs []byte, contentType string, e error) {
+	encoderLock.RLock()
+	candidates := append([]*Encoder{}, encoders...)
+	encoderLock.RUnlock()
+
+	ranges := parseAccept(accept)
+	for _, r := range ranges {
+		if r.mediaType == "text/html" {
+			ranges = nil
+			break
+		}
+	}
+	if len(ranges) == 0 {
+		ranges = []acceptRange{{"*/*", 1}}
+	}
+	tried := map[*Encoder]bool{}
+	for _, r := range ranges {
+		for _, encoder := range candidates {
+			if tried[encoder] {
+				continue
+			}
+			for _, cType := range encoder.ContentTypes {
+				if mediaTypeMatch(r.mediaType, cType) {
+					tried[encoder] = true
+					if bs, e = encoder.Encode(v); e == nil {
+						return bs, cType, nil
+					} else if e != Err_Unencodable {
+						return
+					}
+					break
+				}
+			}
+		}
+	}
+	return nil, "", Err_NotAcceptable
+}
+
+// 按指定格式(json/xml/text/csv...)输出，format 为空时按 Accept 协商
+func (this *Handler) Render(format string, v interface{}) (e error) {
+	var bs []byte
+	var cType string
+	if len(format) == 0 {
+		bs, cType, e = negotiate(this.GetHeader("accept"), v)
+	} else if encoder := getEncoder(format); encoder == nil {
+		e = errors.New("Unknown render format: " + format)
+	} else if bs, e = encoder.Encode(v); e == nil {
+		cType = encoder.ContentTypes[0]
+	}
+	if e == nil {
+		this.ResponseOK()
+		this.ResponseHeader("Content-Type", cType)
+		this.ResponseData(bs)
+	} else if e == Err_NotAcceptable {
+		this.ResponseStatus(406)
+		this.ResponseData(Err_NotAcceptable.Error())
+	}
+	return
+}
+
+func encodeXML(v interface{}) ([]byte, error) {
+	if reflect.Indirect(reflect.ValueOf(v)).Kind() == reflect.Map {
+		return nil, Err_Unencodable
+	}
+	bs, e := xml.Marshal(v)
+	if _, is := e.(*xml.UnsupportedTypeError); is {
+		return nil, Err_Unencodable
+	}
+	return bs, e
+}
+
+// 不引入第三方库，值需要实现 msgp 生成的 MarshalMsg
+func encodeMsgpack(v interface{}) ([]byte, error) {
+	if m, is := v.(interface {
+		MarshalMsg([]byte) ([]byte, error)
+	}); is {
+		return m.MarshalMsg(nil)
+	}
+	return nil, Err_Unencodable
+}
+
+// 不引入第三方库，值需要实现 gogo/protobuf 生成的 Marshal
+func encodeProtobuf(v interface{}) ([]byte, error) {
+	if m, is := v.(interface {
+		Marshal() ([]byte, error)
+	}); is {
+		return m.Marshal()
+	}
+	return nil, Err_Unencodable
+}
+
+func encodeText(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case fmt.Stringer:
+		return []byte(t.String()), nil
+	case error:
+		return []byte(t.Error()), nil
+	}
+	switch reflect.Indirect(reflect.ValueOf(v)).Kind() {
+	case reflect.Map, reflect.Struct, reflect.Slice, reflect.Array:
+		return nil, Err_Unencodable
+	}
+	return []byte(fmt.Sprint(v)), nil
+}
+
+// 支持 [][]string、struct 数组(表头为 json 名)以及 map 数组(表头为排序后的 key)
+func encodeCSV(v interface{}) ([]byte, error) {
+	var rows [][]string
+	if rs, is := v.([][]string); is {
+		rows = rs
+	} else {
+		rv := reflect.Indirect(reflect.ValueOf(v))
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			return nil, Err_Unencodable
+		}
+		et := rv.Type().Elem()
+		if et.Kind() == reflect.Ptr {
+			et = et.Elem()
+		}
+		switch et.Kind() {
+		case reflect.Struct:
+			header, indexes := []string{}, []int{}
+			for i := 0; i < et.NumField(); i++ {
+				if sf := et.Field(i); len(sf.PkgPath) == 0 && sf.Tag.Get("json") != "-" {
+					header = append(header, fieldName(sf))
+					indexes = append(indexes, i)
+				}
+			}
+			rows = append(rows, header)
+			for i := 0; i < rv.Len(); i++ {
+				item := reflect.Indirect(rv.Index(i))
+				row := make([]string, len(indexes))
+				if item.IsValid() {
+					for j, ind := range indexes {
+						row[j] = fmt.Sprint(item.Field(ind).Interface())
+					}
+				}
+				rows = append(rows, row)
+			}
+		case reflect.Map:
+			if et.Key().Kind() != reflect.String {
+				return nil, Err_Unencodable
+			}
+			keySet := map[string]bool{}
+			for i := 0; i < rv.Len(); i++ {
+				for _, k := range rv.Index(i).MapKeys() {
+					keySet[k.String()] = true
+				}
+			}
+			header := make([]string, 0, len(keySet))
+			for k := range keySet {
+				header = append(header, k)
+			}
+			sort.Strings(header)
+			rows = append(rows, header)
+			for i := 0; i < rv.Len(); i++ {
+				item := rv.Index(i)
+				row := make([]string, len(header))
+				for j, k := range header {
+					if value := item.MapIndex(reflect.ValueOf(k).Convert(et.Key())); value.IsValid() {
+						row[j] = fmt.Sprint(value.Interface())
+					}
+				}
+				rows = append(rows, row)
+			}
+		default:
+			return nil, Err_Unencodable
+		}
+	}
+	buff := bytes.NewBuffer([]byte{})
+	writer := csv.NewWriter(buff)
+	if e := writer.WriteAll(rows); e != nil {
+		return nil, e
+	}
+	return buff.Bytes(), nil
+}
+
+func decodeJSON(body []byte) (params map[string]interface{}, e error) {
+	params = map[string]interface{}{}
+	e = json.Unmarshal(body, &params)
+	return
+}
+
+// 把 xml 转成 map: 根节点的子节点作为 key，只有文字的节点为 string，
+// 同名节点合并为数组，属性以 "@" 开头，有子节点时的文字放在 "#text"
+func decodeXML(body []byte) (map[string]interface{}, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		token, e := decoder.Token()
+		if e != nil {
+			return nil, e
+		}
+		if start, is := token.(xml.StartElement); is {
+			value, e := decodeXMLElement(decoder, start)
+			if e != nil {
+				return nil, e
+			}
+			if m, is := value.(map[string]interface{}); is {
+				return m, nil
+			}
+			return map[string]interface{}{start.Name.Local: value}, nil
+		}
+	}
+}
+
+func decodeXMLElement(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	m := map[string]interface{}{}
+	for _, attr := range start.Attr {
+		m["@"+attr.Name.Local] = attr.Value
+	}
+	text := bytes.NewBuffer([]byte{})
+	for {
+		token, e := decoder.Token()
+		if e != nil {
+			return nil, e
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, e := decodeXMLElement(decoder, t)
+			if e != nil {
+				return nil, e
+			}
+			name := t.Name.Local
+			switch exists := m[name].(type) {
+			case nil:
+				m[name] = child
+			case []interface{}:
+				m[name] = append(exists, child)
+			default:
+				m[name] = []interface{}{exists, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(m) == 0 {
+				return s, nil
+			}
+			if len(s) != 0 {
+				m["#text"] = s
+			}
+			return m, nil
+		}
+	}
+}
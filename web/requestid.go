@@ -0,0 +1,87 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"zwei.ren/log"
+)
+
+var (
+	// 接收和回复请求 ID 的请求头，为空时不处理
+	RequestIDHeader = "X-Request-ID"
+	// 客户端没有提供或者提供的不合法时生成新的 ID
+	GenerateRequestID = func() string {
+		bs := make([]byte, 16)
+		rand.Read(bs)
+		return hex.EncodeToString(bs)
+	}
+)
+
+type requestIDKey struct{}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// ctx 中的请求 ID，没有时返回空
+func RequestIDFrom(ctx context.Context) string {
+	if ctx != nil {
+		if id, is := ctx.Value(requestIDKey{}).(string); is {
+			return id
+		}
+	}
+	return ""
+}
+
+// 只接受不超过 128 个可见 ASCII 字符，避免日志注入
+func validRequestID(id string) bool {
+	if len(id) == 0 || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// 读取或生成请求 ID，放进 request 的 context 并写入响应头
+func (this *HttpServer) withRequestID(w http.ResponseWriter, request *http.Request) *http.Request {
+	if len(RequestIDHeader) == 0 {
+		return request
+	}
+	id := request.Header.Get(RequestIDHeader)
+	if !validRequestID(id) {
+		id = GenerateRequestID()
+		request.Header.Set(RequestIDHeader, id)
+	}
+	w.Header().Set(RequestIDHeader, id)
+	return request.WithContext(WithRequestID(request.Context(), id))
+}
+
+// 请求的 context，客户端断开或者服务器关闭时取消
+func (this *Handler) Context() context.Context {
+	return this.Request.Context()
+}
+
+func (this *Handler) RequestID() string {
+	return RequestIDFrom(this.Request.Context())
+}
+
+// 带请求 ID 的日志
+//
+//	this.Log().Error("Save user failed: %v", e)
+func (this *Handler) Log() *log.Tagged {
+	return log.With(this.RequestID())
+}
+
+// 把 ctx 中的请求 ID 加到发出去的请求上
+func ForwardRequestID(ctx context.Context, request *http.Request) {
+	if id := RequestIDFrom(ctx); len(id) != 0 && len(RequestIDHeader) != 0 {
+		request.Header.Set(RequestIDHeader, id)
+	}
+}
@@ -4,21 +4,23 @@ import (
 	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"html/template"
 	"io"
 	"io/ioutil"
 	"mime"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
-	"regexp"
-	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"zwei.ren/console"
@@ -26,18 +28,7 @@ import (
 	"zwei.ren/memory"
 )
 
-const (
-	boundary_find = "boundary="
-)
-
 var (
-	boundary_findlen    = len(boundary_find)
-	pat_multipart_name  *regexp.Regexp
-	pat_multipart_fname *regexp.Regexp
-	pat_multipart_ctype *regexp.Regexp
-
-	tplFolder = "./views"
-
 	Err_Abort = errors.New("Abort by you")
 
 	// routers = []*_Router{}
@@ -49,57 +40,69 @@ var (
 
 	DefaultServer = &HttpServer{IsLog: true}
 
-	XmlParser func([]byte) (map[string]interface{}, error) = nil
-
 	err_UnknownCType           = errors.New("Unknown Content Type")
 	Err_HandleMetUnimplemented = errors.New("The handle method is not implemented")
+	Err_MethodUnimplemented    = errors.New("The handler implements neither Handle() nor the method of the route")
 )
 
 type HttpServer struct {
 	IsLog bool
-
-	routers []*_Router
-	mux     *http.ServeMux
-	server  *http.Server
-}
-
+	// 输出 HTTPError 以及 404/405/413/500 等错误，为nil时使用 DefaultErrorHandler
+	ErrorHandler func(handler IHandler, e *HTTPError)
+	// 按 Accept-Encoding 压缩 handler 的输出，为nil时不压缩
+	Compression *Compression
+	// Handler.Tpl() 使用的模板，为nil时加载 DefaultTplDir
+	Templates *Templates
+	// 访问日志，为nil且 IsLog 为 true 时用 FormatPretty 输出到标准输出
+	AccessLog *AccessLog
+	// Close() 时等待请求结束的最长时间，为0时使用 DefaultDrainTimeout
+	DrainTimeout time.Duration
+	// 健康检查变成 503 之后，继续正常服务多久再停止接受新连接，留给负载均衡摘除的时间
+	ShutdownDelay time.Duration
+	// 可信代理传递客户端地址的请求头，只读取这一个，为空时使用 X-Forwarded-For
+	// 例如 Forwarded、X-Real-IP、CF-Connecting-IP，见 SetTrustedProxies
+	ProxyHeader string
+
+	routers    []*_Router
+	routeNames map[string]string
+	metrics    *metrics
+	// 可信的反向代理，见 SetTrustedProxies
+	trustedProxies []*net.IPNet
+	trustUnix      bool
+	certs          certStore
+	clientCAs      *x509.CertPool
+	clientAuth     tls.ClientAuthType
+	tree           *routeNode
+	middlewares    []Middleware
+	routeLock      sync.RWMutex
+	mux            *http.ServeMux
+	servers        map[*http.Server]struct{} // 每个监听地址一个，Shutdown 时一起关闭
+	serverLock     sync.Mutex
+	drain          drainState
+}
+
+// 平滑关闭，最多等待 DrainTimeout
 func (this *HttpServer) Close() error {
-	if this.server == nil {
-		return errors.New("Not a running server")
-	}
-	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	if e := this.server.Shutdown(ctx); e == nil {
-		this.server = nil
-		return nil
-	} else {
-		return e
+	timeout := this.DrainTimeout
+	if timeout < 1 {
+		timeout = DefaultDrainTimeout
 	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return this.Shutdown(ctx)
 }
 
 type _Router struct {
-	Name    string
-	Len     int
-	Builder func() IHandler
+	Name       string
+	ParamNames []string
+	Builder    func() IHandler
+	AnyMethods []string // Builder 只实现了这些 method 方法，nil 表示全部交给 Handle()
+	Methods    map[string]func() IHandler
+	RouteNames []string
 }
 
 func init() {
 	initMime()
-	initRouter()
-}
-
-func initRouter() (err error) {
-	if pat_multipart_name, err = regexp.Compile(`name="(.+)"\r\n`); err == nil {
-		if pat_multipart_fname, err = regexp.Compile(`name="(.+)"; filename="(.+)"\r\n`); err == nil {
-			pat_multipart_ctype, err = regexp.Compile(`Content-Type:\s(.+)\r\n`)
-		}
-	}
-	return
-}
-
-type MultipartFileData struct {
-	Content     []byte
-	FileName    string
-	ContentType string
 }
 
 type IHandler interface {
@@ -109,12 +112,21 @@ type IHandler interface {
 	Handle()
 	IP() string
 	isOver() bool
-	getResponse() (statusCode int, resHeaders map[string][]string, resData interface{})
+	setPathParams(params map[string]string)
+	release()
+	takeError() *HTTPError
+	setGroup(group *RouterGroup)
+	getGroup() *RouterGroup
+	setServer(server *HttpServer)
+	getServer() *HttpServer
+	GetResponse() (statusCode int, resHeaders map[string][]string, resData interface{})
+	StopRun()
 
 	ResponseNothing() bool
 	ResponseOK()
 	ResponseStatus(code int)
 	ResponseHeaders(headers map[string][]string)
+	ResponseHeader(key string, values ...string)
 	ResponseData(data interface{})
 
 	// OnConnect()
@@ -131,9 +143,21 @@ type Handler struct {
 	hasBody                bool
 	getParams              map[string]string
 	hasGetParams           bool
+	queryValues            url.Values
+	pathParams             map[string]string
+	group                  *RouterGroup
+	server                 *HttpServer
+	csrfToken              string
+	err                    *HTTPError
 	postParams             map[string]interface{}
 	hasPostParams          bool
 	fileParams             map[string]*MultipartFileData
+	fileArrParams          map[string][]*MultipartFileData
+	formValues             url.Values
+	tempFiles              []string
+	statusSet              bool
+	streams                []*EventStream
+	maxUploadSize          *int64
 	reqHeaders             map[string][]string
 	ip                     *string
 	hasReqHeaders          bool
@@ -144,32 +168,6 @@ type Handler struct {
 	ResData    interface{}
 }
 
-func (this *Handler) Tpl(fileName string, datas interface{}, subTpls ...string) (e error) {
-	var tpl *template.Template
-	subCount := 0
-	if subTpls != nil {
-		subCount = len(subTpls)
-	}
-	tplFiles := make([]string, 1+subCount)
-	tplFiles[0] = path.Join(tplFolder, fileName)
-	for i := 0; i < subCount; i++ {
-		tplFiles[i+1] = path.Join(tplFolder, subTpls[i])
-	}
-	if tpl, e = template.ParseFiles(tplFiles...); e == nil {
-		buff := bytes.NewBuffer([]byte{})
-		if e = tpl.Execute(buff, datas); e == nil {
-			this.ResponseOK()
-			this.ResponseData(buff.Bytes())
-		}
-		buff.Reset()
-		buff = nil
-	}
-	if e != nil {
-		log.Error("Router handle tpl failed: req[%s] fn[%s] datas[%v] error[%v]", this.Request.URL.Path, fileName, datas, e)
-	}
-	return
-}
-
 func (this *Handler) GetRouterPath() []string {
 	return strings.Split(this.Request.URL.Path, "/")
 }
@@ -181,6 +179,38 @@ func (this *Handler) GetRouterPathAt(index int) string {
 		return ""
 	}
 }
+
+// 路由中 :name 或 *name 捕获到的值
+func (this *Handler) PathParam(name string) string {
+	return this.pathParams[name]
+}
+func (this *Handler) PathParams() map[string]string {
+	if this.pathParams == nil {
+		this.pathParams = map[string]string{}
+	}
+	return this.pathParams
+}
+func (this *Handler) setPathParams(params map[string]string) {
+	this.pathParams = params
+}
+func (this *Handler) setGroup(group *RouterGroup) {
+	this.group = group
+}
+func (this *Handler) getGroup() *RouterGroup {
+	return this.group
+}
+
+func (this *Handler) setServer(server *HttpServer) {
+	this.server = server
+}
+
+// 处理这个请求的服务器，不在服务器中时返回 DefaultServer
+func (this *Handler) getServer() *HttpServer {
+	if this.server == nil {
+		return DefaultServer
+	}
+	return this.server
+}
 func (this *Handler) initHandler(w http.ResponseWriter, r *http.Request) {
 	this.Writer = w
 	this.Request = r
@@ -243,21 +273,69 @@ func (this *Handler) GetHeaders() map[string][]string {
 func (this *Handler) GetGetParam(key string) (res string) {
 	return this.GetGetParamMap()[key]
 }
+
+// 每个参数只保留第一个值，需要全部的值时使用 GetQueryValues()
 func (this *Handler) GetGetParamMap() map[string]string {
 	if !this.hasGetParams {
 		this.getParams = map[string]string{}
 		this.hasGetParams = true
-		if getForm, err := url.ParseQuery(this.Request.URL.RawQuery); err == nil {
-			for k, vs := range getForm {
-				if len(k) != 0 && len(vs) != 0 && len(vs[0]) != 0 {
-					this.getParams[k] = vs[0]
-				}
+		for k, vs := range this.GetQueryValues() {
+			if len(k) != 0 && len(vs) != 0 {
+				this.getParams[k] = vs[0]
 			}
 		}
 	}
 	return this.getParams
 }
 
+// 保留同名参数的所有值以及空值，例如 ?tag=a&tag=b&flag=
+func (this *Handler) GetQueryValues() url.Values {
+	if this.queryValues == nil {
+		this.queryValues, _ = url.ParseQuery(this.Request.URL.RawQuery)
+	}
+	return this.queryValues
+}
+
+func (this *Handler) GetGetIntParam(key string, defaultVal int) int {
+	if v, exists := this.GetGetParamMap()[key]; exists {
+		if r, e := strconv.Atoi(v); e == nil {
+			return r
+		}
+	}
+	return defaultVal
+}
+
+// ?flag 和 ?flag= 都视为 true
+func (this *Handler) GetGetBoolParam(key string, defaultVal bool) bool {
+	if v, exists := this.GetGetParamMap()[key]; exists {
+		if len(v) == 0 {
+			return true
+		}
+		if r, e := strconv.ParseBool(v); e == nil {
+			return r
+		}
+	}
+	return defaultVal
+}
+
+func (this *Handler) GetGetFloatParam(key string, defaultVal float64) float64 {
+	if v, exists := this.GetGetParamMap()[key]; exists {
+		if r, e := strconv.ParseFloat(v, 64); e == nil {
+			return r
+		}
+	}
+	return defaultVal
+}
+
+func (this *Handler) GetGetDurationParam(key string, defaultVal time.Duration) time.Duration {
+	if v, exists := this.GetGetParamMap()[key]; exists {
+		if r, e := time.ParseDuration(v); e == nil {
+			return r
+		}
+	}
+	return defaultVal
+}
+
 func (this *Handler) GetFileParams() map[string]*MultipartFileData {
 	if !this.hasPostParams {
 		this.GetPostParams()
@@ -274,49 +352,57 @@ func (this *Handler) GetFileParam(key string) (f *MultipartFileData) {
 	return
 }
 
+// 同名的多个文件
+func (this *Handler) GetFileParamArr(key string) []*MultipartFileData {
+	if !this.hasPostParams {
+		this.GetPostParams()
+	}
+	return this.fileArrParams[key]
+}
+
 func (this *Handler) GetPostParams() map[string]interface{} {
 	if !this.hasPostParams {
 		params, fileParams := map[string]interface{}{}, map[string]*MultipartFileData{}
 		var e error
-		if cType := this.GetHeader(`content-type`); len(cType) > 15 {
-			switch cType[:16] {
-			case "application/x-ww":
-				var form url.Values
-				if form, e = url.ParseQuery(string(this.GetBody())); e == nil {
-					for k, vs := range form {
-						if len(k) != 0 && len(vs) != 0 {
-							params[k] = vs[0]
-						}
+		cType := this.GetHeader(`content-type`)
+		mediaType, _, _ := mime.ParseMediaType(cType)
+		switch mediaType {
+		case "application/x-www-form-urlencoded":
+			if this.formValues, e = url.ParseQuery(string(this.GetBody())); e == nil {
+				for k, vs := range this.formValues {
+					if len(k) != 0 && len(vs) != 0 {
+						params[k] = vs[0]
 					}
 				}
-			case "application/json":
-				e = json.Unmarshal(this.GetBody(), &params)
-			case "multipart/form-d":
-				if bInd := strings.Index(cType, boundary_find); bInd != -1 {
-					params, fileParams = parseMultipartForm(this.GetBody(), cType[bInd+boundary_findlen:])
-				}
-			default:
-				e = err_UnknownCType
-				log.Debug("This Content-Type: [%v] doesn't need to be parsed", cType)
 			}
-		} else {
-			switch cType {
-			case "text/xml":
-				var xmlBody map[string]interface{}
-				if XmlParser == nil {
-					log.Error("XML isn't supported")
-				} else if xmlBody, e = XmlParser(this.GetBody()); e != nil {
-					log.Error("XML parser return failed: %v", e)
+		case "multipart/form-data":
+			this.hasBody = true
+			if this.formValues, this.fileArrParams, e = this.parseMultipart(cType); e != nil {
+				log.Error("MultipartForm: parse failed: %v", e)
+			}
+			for k, vs := range this.formValues {
+				params[k] = vs[0]
+			}
+			for k, fs := range this.fileArrParams {
+				fileParams[k] = fs[0]
+			}
+		default:
+			if decode := getDecoder(mediaType); decode != nil {
+				var decoded map[string]interface{}
+				if decoded, e = decode(this.GetBody()); e == nil {
+					params = decoded
 				} else {
-					params = xmlBody
+					log.Error("Decode %s body failed: %v", mediaType, e)
 				}
-			default:
+			} else {
 				e = err_UnknownCType
+				log.Debug("This Content-Type: [%v] doesn't need to be parsed", cType)
 			}
 		}
 		if e == err_UnknownCType {
 			if json.Unmarshal(this.GetBody(), &params) != nil {
 				if form, err := url.ParseQuery(string(this.GetBody())); err == nil {
+					this.formValues = form
 					for k, vs := range form {
 						if len(k) != 0 && len(vs) != 0 {
 							params[k] = vs[0]
@@ -338,6 +424,17 @@ func (this *Handler) GetPostParams() map[string]interface{} {
 	return this.postParams
 }
 
+// 表单(urlencoded 或 multipart)中同名参数的所有值，包括空值
+func (this *Handler) GetFormValues() url.Values {
+	if !this.hasPostParams {
+		this.GetPostParams()
+	}
+	if this.formValues == nil {
+		this.formValues = url.Values{}
+	}
+	return this.formValues
+}
+
 func (this *Handler) GetPostStrParam(key string) (value string) {
 	val := this.GetPostParam(key)
 	var isStr bool
@@ -367,6 +464,47 @@ func (this *Handler) GetPostIntParam(key string, defaultVal int) int {
 	return defaultVal
 }
 
+func (this *Handler) GetPostBoolParam(key string, defaultVal bool) bool {
+	if i, z := this.GetPostParams()[key]; z {
+		switch v := i.(type) {
+		case bool:
+			return v
+		case float64:
+			return v != 0
+		case string:
+			if len(v) == 0 {
+				return true
+			}
+			if r, e := strconv.ParseBool(v); e == nil {
+				return r
+			}
+		default:
+			log.Error("Type of params[%s] is %T", key, i)
+		}
+	}
+	return defaultVal
+}
+
+func (this *Handler) GetPostFloatParam(key string, defaultVal float64) float64 {
+	if i, z := this.GetPostParams()[key]; z {
+		switch v := i.(type) {
+		case float64:
+			return v
+		case int:
+			return float64(v)
+		case int64:
+			return float64(v)
+		case string:
+			if r, e := strconv.ParseFloat(v, 64); e == nil {
+				return r
+			}
+		default:
+			log.Error("Type of params[%s] is %T", key, i)
+		}
+	}
+	return defaultVal
+}
+
 func (this *Handler) GetPostParam(key string) (value interface{}) {
 	value, _ = this.GetPostParams()[key]
 	return
@@ -379,20 +517,22 @@ func (this *Handler) ParseParams(pointer interface{}) error {
 func (this *Handler) GetBody() (body []byte) {
 	if !this.hasBody {
 		this.hasBody = true
-		this.body, _ = ioutil.ReadAll(this.Request.Body)
+		var e error
+		this.body, e = ioutil.ReadAll(this.limitedBody())
 		this.Request.Body.Close()
+		if isTooLarge(e) {
+			this.body = nil
+			this.abortTooLarge()
+		}
 	}
 	return this.body
 }
 
+// 客户端 IP，调用 SetTrustedProxies 之前不会读取 X-Forwarded-For 等请求头，
+// 总是直接连接的地址
 func (this *Handler) IP() (ip string) {
 	if this.ip == nil {
-		if ip = this.GetHeader("x-forwarded-for"); len(ip) == 0 {
-			ip = this.Request.RemoteAddr
-			if ind := strings.LastIndex(ip, ":"); ind != -1 {
-				ip = ip[:ind]
-			}
-		}
+		ip, _ = this.getServer().resolveClient(this.Request)
 		this.ip = &ip
 	} else {
 		ip = *this.ip
@@ -402,10 +542,19 @@ func (this *Handler) IP() (ip string) {
 
 func (this *Handler) ResponseStatus(code int) {
 	this.ResCode = code
+	this.statusSet = true
 }
 func (this *Handler) ResponseHeaders(headers map[string][]string) {
 	this.ResHeaders = headers
 }
+
+// 设置单个返回头，会覆盖同名的头
+func (this *Handler) ResponseHeader(key string, values ...string) {
+	if this.ResHeaders == nil {
+		this.ResHeaders = map[string][]string{}
+	}
+	this.ResHeaders[key] = values
+}
 func (this *Handler) ResponseData(data interface{}) {
 	this.ResData = data
 }
@@ -439,10 +588,19 @@ func (this *Handler) ResponseAccessCrossOrigin(headers map[string][]string, allo
 	this.ResponseHeaders(m)
 }
 
-func (this *Handler) getResponse() (int, map[string][]string, interface{}) {
+func (this *Handler) GetResponse() (int, map[string][]string, interface{}) {
 	return this.ResCode, this.ResHeaders, this.ResData
 }
 
+func hasHeader(headers map[string][]string, key string) bool {
+	for k, vs := range headers {
+		if strings.EqualFold(k, key) && len(vs) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func HandleException(msg string) {
 	if err := recover(); err != nil {
 		if err == Err_Abort {
@@ -453,15 +611,21 @@ func HandleException(msg string) {
 	}
 }
 
-func AddFileRouter(routerName, folder string, filter func([]string, os.FileInfo) bool) {
-	DefaultServer.AddFileRouter(routerName, folder, filter)
+func AddFileRouter(routerName, folder string, filter func([]string, os.FileInfo) bool, options ...StaticOption) {
+	DefaultServer.AddFileRouter(routerName, folder, filter, options...)
 }
-func (this *HttpServer) AddFileRouter(routerName, folder string, filter func([]string, os.FileInfo) bool) {
+func (this *HttpServer) AddFileRouter(routerName, folder string, filter func([]string, os.FileInfo) bool, options ...StaticOption) {
 	this.AddRouter(routerName, func() IHandler {
-		return &staticRouter{Folder: folder, NoFilter: filter == nil, Filter: filter}
+		router := &staticRouter{Folder: folder, NoFilter: filter == nil, Filter: filter}
+		for _, option := range options {
+			option(router)
+		}
+		return router
 	})
 }
 
+// suffixes 中的文件按 Accept-Encoding 压缩返回，优先使用同目录下预先压缩好的 .br/.zst/.gz 文件，
+// openCache 开启 ETag/Last-Modified 缓存，同时把压缩结果缓存在内存中
 func AddGZipStaticRouter(routerName, folder string, openCache bool, suffixes ...string) {
 	DefaultServer.AddGZipStaticRouter(routerName, folder, openCache, suffixes...)
 }
@@ -488,18 +652,47 @@ func (this *HttpServer) AddGZipStaticRouter(routerName, folder string, openCache
 	})
 }
 
-func AddStaticRouter(routerName, folder string) {
-	DefaultServer.AddStaticRouter(routerName, folder)
+func AddStaticRouter(routerName, folder string, options ...StaticOption) {
+	DefaultServer.AddStaticRouter(routerName, folder, options...)
 }
 
-func (this *HttpServer) AddStaticRouter(routerName, folder string) {
+func (this *HttpServer) AddStaticRouter(routerName, folder string, options ...StaticOption) {
 	this.AddRouter(routerName, func() IHandler {
-		return &staticRouter{Folder: folder, NoFilter: true}
+		router := &staticRouter{Folder: folder, NoFilter: true}
+		for _, option := range options {
+			option(router)
+		}
+		return router
 	})
 }
 
 func SetTplDir(folder string) {
-	tplFolder = folder
+	DefaultServer.SetTplDir(folder)
+}
+
+// 设置这个服务器的模板目录，需要更多配置时直接设置 HttpServer.Templates
+func (this *HttpServer) SetTplDir(folder string) {
+	this.routeLock.Lock()
+	defer this.routeLock.Unlock()
+	if this.Templates != nil {
+		this.Templates.Close()
+	}
+	this.Templates = NewTemplates(folder)
+}
+
+func (this *HttpServer) templates() *Templates {
+	this.routeLock.RLock()
+	templates := this.Templates
+	this.routeLock.RUnlock()
+	if templates == nil {
+		this.routeLock.Lock()
+		if this.Templates == nil {
+			this.Templates = NewTemplates(DefaultTplDir)
+		}
+		templates = this.Templates
+		this.routeLock.Unlock()
+	}
+	return templates
 }
 
 type staticRouter struct {
@@ -510,12 +703,18 @@ type staticRouter struct {
 	GZipSuffixes map[string]bool
 	Filter       func([]string, os.FileInfo) bool
 	OpenCache    bool
+	Listing      bool
+	ShowHidden   bool
+	DenyHidden   bool
 }
 
 func (this *staticRouter) Handle() {
 	if paths := this.GetRouterPath(); len(paths) > 1 {
-		paths[1] = this.Folder
-		filePath := path.Join(paths[1:]...)
+		rel := strings.Join(paths[2:], "/")
+		filePath, e := this.SafeFilePath(this.Folder, rel, !this.DenyHidden)
+		if e == Err_UnsafePath || e == Err_HiddenPath {
+			this.Fail(e)
+		}
 		info, e := os.Stat(filePath)
 		if e == nil {
 			if info.IsDir() {
@@ -523,8 +722,21 @@ func (this *staticRouter) Handle() {
 					this.Redirect(302, path+"/")
 					return
 				}
-				filePath = path.Join(filePath, "index.html")
-				info, e = os.Stat(filePath)
+				dirPath, dirRel, dirInfo := filePath, rel, info
+				rel = path.Join(rel, "index.html")
+				if filePath, e = SafeJoin(this.Folder, rel, !this.DenyHidden); e == nil {
+					info, e = os.Stat(filePath)
+				}
+				if e != nil && this.Listing {
+					// 不显示隐藏文件时，也不能列出隐藏目录(例如 .git)里面的内容
+					if _, e = SafeJoin(this.Folder, dirRel, this.ShowHidden); e == Err_HiddenPath {
+						this.Fail(e)
+					}
+					if this.NoFilter || this.Filter(paths[2:], dirInfo) {
+						this.responseListing(dirPath, paths[2:])
+					}
+					return
+				}
 			}
 			if e == nil {
 				if this.NoFilter || this.Filter(paths[2:], info) {
@@ -535,12 +747,17 @@ func (this *staticRouter) Handle() {
 							"Content-Type": {mime.TypeByExtension(path.Ext(filePath))},
 						}
 					}
+					this.ResHeaders["Accept-Ranges"] = []string{"bytes"}
+					compressible := this.GZip && this.GZipSuffixes[path.Ext(this.Request.URL.Path)]
+					if compressible {
+						this.ResHeaders["Vary"] = []string{"Accept-Encoding"}
+					}
+					etag := `"` + strconv.FormatInt(info.ModTime().Unix(), 16) + "-" + strconv.FormatInt(size, 16) + `"`
 
 					if this.OpenCache {
 						switch path.Ext(filePath) {
 						case ".mp3", ".wav", ".avi", ".mp4":
 						default:
-							etag := `"` + strconv.FormatInt(info.ModTime().Unix(), 16) + "-" + strconv.FormatInt(size, 16) + `"`
 							lastModify := info.ModTime().Format(http.TimeFormat)
 
 							_lm, _etag := this.GetHeader("if-modified-since"), this.GetHeader("if-none-match")
@@ -550,7 +767,7 @@ func (this *staticRouter) Handle() {
 
 							if len(_lm) != 0 || len(_etag) != 0 { // 浏览器有缓存机制
 								if (len(_lm) == 0 || _lm == lastModify) &&
-									(len(_etag) == 0 || _etag == etag) { // 都命中了
+									(len(_etag) == 0 || matchETag(_etag, etag)) { // 都命中了
 									this.ResponseStatus(http.StatusNotModified)
 									return
 								}
@@ -558,72 +775,28 @@ func (this *staticRouter) Handle() {
 						}
 					}
 
-					var rangeBytesFrom, rangeBytesTo int64 = -1, -1
-					if bytesRange := this.GetHeader("range"); len(bytesRange) > 7 && bytesRange[:6] == "bytes=" {
-						fmt.Println("Range:", bytesRange)
-						bytesRange = bytesRange[6:]
-						if ind := strings.Index(bytesRange, ","); ind != -1 {
-							bytesRange = bytesRange[:ind]
-						}
-						var ind int64
-						var _e error
-						if len(bytesRange) != 0 {
-							if bytesRange[0] == '-' {
-								if ind, _e = strconv.ParseInt(bytesRange, 10, 64); _e == nil && ind+size > -1 {
-									rangeBytesFrom, rangeBytesTo = size+ind-1, size-1
-								}
-							} else if from_to := strings.Split(bytesRange, "-"); len(from_to) == 2 {
-								if ind, _e = strconv.ParseInt(from_to[0], 10, 64); _e == nil && ind > -1 {
-									if len(from_to[1]) == 0 {
-										rangeBytesFrom, rangeBytesTo = ind, size-1
-									} else if rangeBytesTo, _e = strconv.ParseInt(from_to[1], 10, 64); _e == nil && rangeBytesTo < size && rangeBytesTo >= ind {
-										rangeBytesFrom = ind
-									}
-								}
-							}
-							if rangeBytesTo-rangeBytesFrom > MaxStaticFileSize {
-								rangeBytesFrom = -1
-							} else if rangeBytesFrom == 0 && rangeBytesTo == size-1 {
-								rangeBytesFrom = -1
-							}
+					// Range 只在 If-Range 匹配时生效，格式不对时忽略，返回整个文件
+					var ranges []byteRange
+					if bytesRange := this.GetHeader("range"); len(bytesRange) != 0 &&
+						checkIfRange(this.GetHeader("if-range"), etag, info.ModTime()) {
+						if ranges, e = parseRange(bytesRange, size); e == err_NoOverlapRange {
+							this.responseRangeNotSatisfiable(size)
+							return
+						} else if e != nil || len(ranges) > MaxRanges {
+							ranges, e = nil, nil
+						} else if len(ranges) == 1 && ranges[0].start == 0 && ranges[0].length == size {
+							ranges = nil
 						}
 					}
 
 					var bs []byte
-					if rangeBytesFrom != -1 {
+					if ranges != nil {
 						var file *os.File
-						if file, e = os.OpenFile(filePath, os.O_RDONLY, 0666); e == nil {
-							if _, e = file.Seek(rangeBytesFrom, os.SEEK_SET); e == nil {
-								rangeCount := int(rangeBytesTo - rangeBytesFrom + 1)
-								readCount := 0
-								buff := make([]byte, rangeCount)
-								var _tempRc int
-								for e == nil && readCount < rangeCount {
-									if _tempRc, e = file.Read(buff[readCount:]); _tempRc > 0 {
-										readCount += _tempRc
-									}
-								}
-								if readCount == rangeCount {
-									if e == io.EOF {
-										e = nil
-									}
-									this.ResHeaders["Content-Length"] = []string{
-										strconv.Itoa(rangeCount),
-									}
-									this.ResHeaders["Content-Range"] = []string{
-										fmt.Sprintf("bytes %d-%d/%d",
-											rangeBytesFrom, rangeBytesTo, size,
-										),
-									}
-									this.ResponseData(buff)
-									this.ResponseStatus(206)
-								} else {
-									e = errors.New("File changed")
-								}
-
-							}
-							file.Close()
+						if file, e = os.Open(filePath); e == nil {
+							this.responseRanges(file, ranges, mime.TypeByExtension(path.Ext(filePath)), size)
 						}
+					} else if compressible && this.responseEncoded(rel, filePath, info) {
+						// 已经按 Accept-Encoding 返回了压缩后的内容
 					} else if size > MaxStaticFileSize { // 文件太大了
 						var file *os.File
 						if file, e = os.OpenFile(filePath, os.O_RDONLY, 0666); e == nil {
@@ -635,23 +808,6 @@ func (this *staticRouter) Handle() {
 						}
 					} else if bs, e = ioutil.ReadFile(filePath); e == nil {
 						this.ResponseOK()
-						if this.GZip && this.GZipSuffixes[path.Ext(this.Request.RequestURI)] {
-							acceptGZip := false
-							for _, s := range strings.Split(this.GetHeader("accept-encoding"), ",") {
-								if strings.TrimSpace(s) == "gzip" {
-									acceptGZip = true
-									break
-								}
-							}
-							if acceptGZip {
-								compressed := GZipCompress(bs)
-								if len(compressed) < len(bs) {
-									this.ResHeaders["Content-Encoding"] = []string{"gzip"}
-									this.ResponseData(compressed)
-									return
-								}
-							}
-						}
 						this.ResHeaders["Content-Length"] = []string{
 							strconv.FormatInt(size, 10),
 						}
@@ -673,6 +829,7 @@ func (this *staticRouter) Handle() {
 type Stream struct {
 	Reader   io.ReadCloser
 	BuffSize int
+	Flush    bool // 每次读到数据都立即发给客户端
 }
 
 func (this *Handler) Redirect(code int, url string) {
@@ -682,22 +839,37 @@ func (this *Handler) Redirect(code int, url string) {
 	this.ResponseStatus(code)
 }
 
-func checkBuilder(handlerBuilder func() IHandler) (e error) {
+// 检查 handler 是否实现了 Handle() 或者 Get()/Post() 等方法
+// methods 为nil时表示实现了 Handle()，method 不为空时还要实现了这个 method 对应的方法
+func checkBuilder(handlerBuilder func() IHandler, method string) (methods []string, e error) {
 	defer func() {
-		if _e := recover(); _e == Err_HandleMetUnimplemented {
+		if _e := recover(); _e != Err_HandleMetUnimplemented {
+			methods = nil
+		} else if len(methods) == 0 {
 			e = Err_HandleMetUnimplemented
+		} else if len(method) != 0 && !hasMethod(methods, method) {
+			e = Err_MethodUnimplemented
 		}
 	}()
-	handlerBuilder().Handle()
+	handler := handlerBuilder()
+	methods = handlerMethods(handler)
+	handler.Handle()
 	return
 }
 
-func AddRouter(httpPath string, handlerBuilder func() IHandler) {
-	DefaultServer.AddRouter(httpPath, handlerBuilder)
+func AddRouter(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	DefaultServer.AddRouter(httpPath, handlerBuilder, name...)
+}
+
+// name 为路由名，可以通过 URL(name, ...) 生成路径
+func (this *HttpServer) AddRouter(httpPath string, handlerBuilder func() IHandler, name ...string) {
+	this.addRouter("", httpPath, handlerBuilder, name...)
 }
 
-func (this *HttpServer) AddRouter(httpPath string, handlerBuilder func() IHandler) {
-	if e := checkBuilder(handlerBuilder); e != nil {
+// method 为空时处理所有的 method
+func (this *HttpServer) addRouter(method, httpPath string, handlerBuilder func() IHandler, names ...string) {
+	methods, e := checkBuilder(handlerBuilder, method)
+	if e != nil {
 		panic("Router [" + httpPath + "] wrong:" + e.Error())
 	}
 
@@ -707,256 +879,301 @@ func (this *HttpServer) AddRouter(httpPath string, handlerBuilder func() IHandle
 	if handlerBuilder == nil {
 		panic(fmt.Sprintf("Http handler of %v is nil! ", httpPath))
 	} else {
+		pattern := httpPath
 		if httpPath[len(httpPath)-1] != '/' {
 			httpPath += "/"
 		}
-		if this.routers == nil {
-			this.routers = []*_Router{}
+		segs, paramNames := parseRoutePattern(httpPath)
+
+		this.routeLock.Lock()
+		defer this.routeLock.Unlock()
+		for _, name := range names {
+			if exists, has := this.routeNames[name]; has && exists != pattern {
+				panic("Route name '" + name + "' already used by " + exists)
+			}
+		}
+		if this.tree == nil {
+			this.tree = &routeNode{}
 		}
-		for _, r := range this.routers {
-			if r.Name == httpPath {
+		node := this.tree.insert(httpPath, segs)
+		r := node.router
+		if r == nil {
+			r = &_Router{Name: httpPath, ParamNames: paramNames}
+			node.router = r
+			this.routers = append(this.routers, r)
+		}
+		if len(method) == 0 {
+			if r.Builder != nil {
 				panic("Cannot add same router")
 			}
+			r.Builder, r.AnyMethods = handlerBuilder, methods
+		} else {
+			if r.Methods[method] != nil {
+				panic("Cannot add same router: " + method + " " + httpPath)
+			}
+			if r.Methods == nil {
+				r.Methods = map[string]func() IHandler{}
+			}
+			r.Methods[method] = handlerBuilder
+		}
+		for _, name := range names {
+			this.nameRoute(name, pattern, r)
 		}
-		this.routers = append(this.routers, &_Router{
-			Name:    httpPath,
-			Len:     len(httpPath),
-			Builder: handlerBuilder,
-		})
 	}
 }
 
-type _RouterArr []*_Router
-
-func (this _RouterArr) Len() int {
-	return len(this)
-}
-func (this _RouterArr) Less(i, j int) bool {
-	return this[j].Name < this[i].Name
-}
-func (this _RouterArr) Swap(i, j int) {
-	this[i], this[j] = this[j], this[i]
+// 根据请求路径找到路由，以及路由参数
+func (this *HttpServer) match(uri string) (rout *_Router, params map[string]string) {
+	this.routeLock.RLock()
+	defer this.routeLock.RUnlock()
+	if this.tree == nil {
+		return
+	}
+	var values []string
+	if rout, values = this.tree.find(splitRoutePath(uri), nil); rout != nil && len(values) != 0 {
+		params = make(map[string]string, len(values))
+		for i, v := range values {
+			params[rout.ParamNames[i]] = v
+		}
+	}
+	return
 }
 
 func RouterRunWithTimeout(port int, readTimeout, writeTimeout time.Duration) error {
 	return DefaultServer.RouterRunWithTimeout(port, readTimeout, writeTimeout)
 }
 
-func (this *HttpServer) log(
-	status_ptr *int, from time.Time,
-	request *http.Request,
-	handler *IHandler,
-) {
-	var addr, met string
-	if handler != nil && *handler != nil {
-		addr = (*handler).IP()
-	}
-	if len(addr) == 0 {
-		addr = request.RemoteAddr
-	}
-	met = request.Method
-
-	now := time.Now()
-
-	status := *status_ptr
-	var _status, _met string
-	if status < 200 || status > 399 {
-		_status = console.Red(strconv.Itoa(status))
-	} else {
-		_status = console.Magenta(strconv.Itoa(status))
-	}
-	switch met {
-	case "POST":
-		_met = console.Yellow(met)
-	case "PUT":
-		_met = console.Black(met)
-	case "DELETE":
-		_met = console.Magenta(met)
-	case "OPTIONS":
-		_met = console.Cyan(met)
+func formatBytes(size int64) string {
+	switch {
+	case size < 1024:
+		return strconv.FormatInt(size, 10) + "B"
+	case size < 1024*1024:
+		return strconv.FormatFloat(float64(size)/1024, 'f', 1, 64) + "KB"
 	default:
-		_met = console.Blue(met)
+		return strconv.FormatFloat(float64(size)/1024/1024, 'f', 1, 64) + "MB"
 	}
-	fmt.Printf(
-		"[Zwei.Ren/Web] %s | %3s | %12v |%21s | %5s | %s\n",
-		now.Format("2006-01-02 15:04:05"),
-		_status,
-		now.Sub(from),
-		addr,
-		_met,
-		console.Green(request.URL.String()),
-	)
 }
 
 func (this *HttpServer) RouterRunWithTimeout(port int, readTimeout, writeTimeout time.Duration) error {
-	isLog := this.IsLog
-	routers := this.routers
-	mux := this.mux
-	server := this.server
+	server, e := this.prepare(fmt.Sprintf(":%d", port), readTimeout, writeTimeout, false)
+	if e != nil {
+		return e
+	}
+	return this.logRun("port", strconv.Itoa(port), server.ListenAndServe)
+}
 
-	if routers == nil {
-		routers = []*_Router{}
+// 为一个监听地址创建 http.Server，地址和超时互不影响，可以在多个协程中同时调用，
+// secure 时设置 TLSConfig，正在关闭时返回 http.ErrServerClosed
+func (this *HttpServer) prepare(addr string, readTimeout, writeTimeout time.Duration, secure bool) (*http.Server, error) {
+	this.serverLock.Lock()
+	defer this.serverLock.Unlock()
+	if this.mux == nil {
+		this.mux = http.NewServeMux()
+		this.mux.HandleFunc("/", this.serveHTTP)
 	}
-	if mux == nil {
-		mux = http.NewServeMux()
-		this.mux = mux
+	if len(this.servers) == 0 {
+		// 关闭之后重新运行
+		atomic.StoreInt32(&this.drain.draining, 0)
+		this.servers = map[*http.Server]struct{}{}
+	} else if !this.Ready() {
+		return nil, http.ErrServerClosed
 	}
-	if server == nil {
-		server = &http.Server{
-			Addr:              fmt.Sprintf(":%d", port),
-			ReadTimeout:       readTimeout,
-			WriteTimeout:      writeTimeout,
-			IdleTimeout:       readTimeout,
-			ReadHeaderTimeout: readTimeout,
-		}
-		this.server = server
+	server := &http.Server{
+		Addr:              addr,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       readTimeout,
+		ReadHeaderTimeout: readTimeout,
+		Handler:           this.mux,
+	}
+	if secure {
+		server.TLSConfig = this.tlsConfig()
 	}
+	this.servers[server] = struct{}{}
+	return server, nil
+}
 
-	sort.Sort(_RouterArr(routers))
+func (this *HttpServer) logRun(kind, addr string, run func() error) error {
+	fmt.Println(
+		console.Cyan("[Zwei.Ren/Web] Running on "+kind+" ") +
+			console.Magenta(addr) +
+			console.Cyan(" with ") +
+			console.Blue(strconv.Itoa(len(this.routers))) +
+			console.Cyan(" routers."),
+	)
+	e := run()
+	fmt.Println(
+		console.Red("[Zwei.Ren/Web] Stop service on "+kind+" ") +
+			console.Magenta(addr) +
+			console.Red(" with error: ") +
+			console.Blue(e.Error()) +
+			console.Red("."),
+	)
+	return e
+}
 
-	// for i, r := range routers {
-	// 	fmt.Println(i, ">", r.Name)
-	// }
-	mux.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-		defer HandleException(request.RequestURI)
-		defer request.Body.Close()
+func (this *HttpServer) serveHTTP(w http.ResponseWriter, request *http.Request) {
+	defer HandleException(request.RequestURI)
+	defer request.Body.Close()
 
-		status := 404
-		var handler IHandler
-		if isLog {
-			defer this.log(&status, time.Now(), request, &handler)
-		}
+	writer := &responseWriter{ResponseWriter: w, server: this}
+	defer writer.release()
+	request = this.withRequestID(writer, request)
+	status := 404
+	var handler IHandler
+	var rout *_Router
+	if this.IsLog || this.AccessLog != nil {
+		defer this.log(&status, time.Now(), request, writer, &handler, &rout)
+	}
+	if metrics := this.getMetrics(); metrics != nil {
+		metrics.begin()
+		writer.metrics = metrics
+		defer metrics.observe(&status, time.Now(), request, writer, &rout)
+	}
 
-		uri := request.URL.Path
-		uriLen := len(uri)
-		if uriLen == 0 {
-			writer.WriteHeader(status)
-			return
-		}
-		if uri[uriLen-1] != '/' {
-			uri += "/"
-			uriLen++
+	uri := request.URL.Path
+	if len(uri) == 0 {
+		writer.WriteHeader(status)
+		return
+	}
+	var handle func()
+	var params map[string]string
+	rout, params = this.match(uri)
+	if rout == nil {
+		base := new(Handler)
+		handler, handle = base, func() {
+			base.Fail(&HTTPError{Code: http.StatusNotFound})
 		}
-		var rout *_Router
-		for _, r := range routers {
-			if uriLen >= r.Len && uri[:r.Len] == r.Name {
-				rout = r
-				break
+	} else if builder, allow := rout.resolve(request.Method); builder == nil {
+		base := new(Handler)
+		handler, handle = base, func() {
+			base.ResponseHeader("Allow", allow)
+			if request.Method == http.MethodOptions {
+				base.ResponseStatus(http.StatusNoContent)
+			} else {
+				base.Fail(&HTTPError{Code: http.StatusMethodNotAllowed})
 			}
 		}
-		if rout == nil {
-			writer.WriteHeader(status)
-			return
+	} else {
+		handler = builder()
+		handle = func() {
+			handler.Prepare()
+			if !handler.isOver() && !callMethodHandler(handler, request.Method) {
+				handler.Handle()
+			}
 		}
+	}
+	handler.initHandler(writer, request)
+	handler.setServer(this)
+	handler.setPathParams(params)
+	defer handler.release()
+	this.runMiddlewares(handler, this.chain(handler.getGroup()), handle)
+	if handler.ResponseNothing() {
+		return
+	}
+	if status, _, resData := handler.GetResponse(); status >= 400 && resData == nil {
+		this.renderError(handler, &HTTPError{Code: status})
+	}
 
-		handler = rout.Builder()
-		handler.initHandler(writer, request)
-		handler.Prepare()
-		if !handler.isOver() {
-			handler.Handle()
-			if handler.ResponseNothing() {
-				return
+	var headers map[string][]string
+	var resData interface{}
+	status, headers, resData = handler.GetResponse()
+
+	// 指定了headers就不用自己找Content-Type了
+	// 未指定的情况下，如果是interface{}就按 Accept 编码，否则是根据mime
+	isNoHeaders := headers == nil || len(headers) == 0
+	isEncoded := false
+	switch resData.(type) {
+	case nil, string, []byte, *Stream:
+	default:
+		isEncoded = true
+		if bs, cType, e := negotiate(request.Header.Get("Accept"), resData); e == nil {
+			if !hasHeader(headers, "Content-Type") {
+				handler.ResponseHeader("Content-Type", cType)
+			}
+			handler.ResponseData(bs)
+		} else {
+			if e != Err_NotAcceptable {
+				log.Error("Encode response of %s failed: %v", request.URL.Path, e)
 			}
+			this.renderError(handler, toHTTPError(e))
 		}
+		status, headers, resData = handler.GetResponse()
+	}
+	if status < 1 {
+		status = 405
+	}
 
-		var headers map[string][]string
-		var resData interface{}
-		status, headers, resData = handler.getResponse()
-		if status < 1 {
-			status = 405
+	writeHeader := writer.Header()
+	for hk, hv := range headers {
+		for _, v := range hv {
+			writeHeader.Add(hk, v)
 		}
+	}
 
-		// 指定了headers就不用自己找Content-Type了
-		// 未指定的情况下，如果是interface{}就json，否则是根据mime
-		isNoHeaders := headers == nil || len(headers) == 0
-
-		writeHeader := writer.Header()
-		if !isNoHeaders {
-			for hk, hv := range headers {
-				for _, v := range hv {
-					writeHeader.Add(hk, v)
-				}
-			}
+	var writeBs []byte
+	var readStream *Stream
+	switch v := resData.(type) {
+	case string:
+		writeBs = []byte(v)
+	case []byte:
+		writeBs = v
+	case *Stream:
+		readStream = v
+	}
+	if resData != nil && isNoHeaders && !isEncoded {
+		if cType := mime.TypeByExtension(path.Ext(request.URL.Path)); len(cType) != 0 {
+			writeHeader.Set(
+				"Content-Type",
+				cType,
+			)
 		}
+	}
+	if readStream == nil && len(writeBs) == 0 {
+		writeBs = []byte("Unknown Response")
+	}
 
-		var writeBs []byte
-		var readStream *Stream
-		if resData != nil {
-			isNoJson := true
-			switch v := resData.(type) {
-			case string:
-				writeBs = []byte(v)
-			case []byte:
-				writeBs = v
-			case *Stream:
-				readStream = v
-			default:
-				writeBs, _ = json.Marshal(resData)
-				isNoJson = false
-			}
-			if isNoHeaders {
-				if isNoJson {
-					if cType := mime.TypeByExtension(path.Ext(request.URL.Path)); len(cType) != 0 {
-						writeHeader.Set(
-							"Content-Type",
-							cType,
-						)
-					}
-				} else {
-					writeHeader.Set(
-						"Content-Type",
-						"application/json",
-					)
-				}
-			}
+	var out io.Writer = writer
+	if compression := this.Compression; compression != nil {
+		size := -1
+		if readStream == nil {
+			size = len(writeBs)
 		}
-		writer.WriteHeader(status)
+		if cw := compression.wrap(writer, request, status, size); cw != nil {
+			defer cw.Close()
+			out = cw
+		}
+	}
+	writer.WriteHeader(status)
 
-		if readStream == nil {
-			if writeBs == nil || len(writeBs) == 0 {
-				writeBs = []byte("Unknown Response")
-			}
-			writer.Write(writeBs)
-		} else {
-			defer readStream.Reader.Close()
-			buffSize := readStream.BuffSize
-			if buffSize < 1 {
-				buffSize = StreamBuffSize
-			}
-			buff := make([]byte, buffSize)
-			var rc, wc int
-			var e error
-			for {
-				if rc, e = readStream.Reader.Read(buff); rc != 0 {
-					if wc, e = writer.Write(buff[:rc]); wc != rc {
-						break
-					}
-				}
-				if e != nil {
+	if readStream == nil {
+		out.Write(writeBs)
+	} else {
+		defer readStream.Reader.Close()
+		buffSize := readStream.BuffSize
+		if buffSize < 1 {
+			buffSize = StreamBuffSize
+		}
+		buff := make([]byte, buffSize)
+		var rc, wc int
+		var e error
+		for {
+			if rc, e = readStream.Reader.Read(buff); rc != 0 {
+				if wc, e = out.Write(buff[:rc]); wc != rc {
 					break
 				}
+				if readStream.Flush {
+					if flusher, is := out.(interface{ Flush() error }); is {
+						flusher.Flush()
+					}
+					writer.Flush()
+				}
+			}
+			if e != nil {
+				break
 			}
 		}
-	})
-
-	server.Handler = mux
-
-	fmt.Println(
-		console.Cyan("[Zwei.Ren/Web] Running on port ") +
-			console.Magenta(strconv.Itoa(port)) +
-			console.Cyan(" with ") +
-			console.Blue(strconv.Itoa(len(routers))) +
-			console.Cyan(" routers."),
-	)
-	e := server.ListenAndServe()
-	fmt.Println(
-		console.Red("[Zwei.Ren/Web] Stop service on port ") +
-			console.Magenta(strconv.Itoa(port)) +
-			console.Red(" with error: ") +
-			console.Blue(e.Error()) +
-			console.Red("."),
-	)
-	return e
+	}
 }
 
 func Run(port int) error {
@@ -967,54 +1184,6 @@ func (this *HttpServer) Run(port int) error {
 	return this.RouterRunWithTimeout(port, ReadTimeout, WriteTimeout)
 }
 
-func AsyncRun(port int) chan error {
-	return DefaultServer.AsyncRun(port)
-}
-
-func (this *HttpServer) AsyncRun(port int) chan error {
-	listener := make(chan error)
-	go func(list chan error) {
-		list <- this.Run(port)
-	}(listener)
-	return listener
-}
-
-func parseMultipartForm(body []byte, boundary string) (strValues map[string]interface{}, fileValues map[string]*MultipartFileData) {
-
-	strValues = map[string]interface{}{}
-	fileValues = map[string]*MultipartFileData{}
-
-	forms := bytes.Split(body, []byte("--"+boundary))
-	formCount := len(forms)
-	if formCount > 1 {
-		var findIndss [][]int
-		var name string
-		var fbean *MultipartFileData
-		for _, form := range forms[1 : formCount-1] {
-			if findIndss = pat_multipart_fname.FindAllSubmatchIndex(form, 1); len(findIndss) == 1 { // file
-
-				name = string(form[findIndss[0][2]:findIndss[0][3]])
-				fbean = &MultipartFileData{FileName: string(form[findIndss[0][4]:findIndss[0][5]])}
-				form = form[findIndss[0][1]:]
-				if findIndss = pat_multipart_ctype.FindAllSubmatchIndex(form, 1); len(findIndss) == 1 {
-					fbean.ContentType = string(form[findIndss[0][2]:findIndss[0][3]])
-					fbean.Content = form[findIndss[0][1]+2 : len(form)-2]
-					fileValues[name] = fbean
-				} else {
-					log.Error("MultipartForm: Cannot find 'contenttype=xxx' at file entry: %v", string(form))
-				}
-			} else if findIndss = pat_multipart_name.FindAllSubmatchIndex(form, 1); len(findIndss) == 1 { // str
-
-				strValues[string(form[findIndss[0][2]:findIndss[0][3]])] = string(form[findIndss[0][1]+2 : len(form)-2])
-			} else {
-				log.Error("MultipartForm: Cannot find 'filename=xxx' or 'name=xxx' at %v", string(form))
-			}
-		}
-	}
-
-	return
-}
-
 func URLEncode(s string) string {
 	return url.QueryEscape(s)
 }
@@ -0,0 +1,114 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+var Err_RouteNotFound = errors.New("Route not found")
+
+// 注册路由名，同一个名字不能用在不同的路径上(在 addRouter 中检查)
+func (this *HttpServer) nameRoute(name, pattern string, r *_Router) {
+	if len(name) == 0 {
+		return
+	}
+	if this.routeNames == nil {
+		this.routeNames = map[string]string{}
+	}
+	if _, has := this.routeNames[name]; !has {
+		this.routeNames[name] = pattern
+		r.RouteNames = append(r.RouteNames, name)
+	}
+}
+
+func URL(name string, pairs ...interface{}) (string, error) {
+	return DefaultServer.URL(name, pairs...)
+}
+
+// 按路由名生成路径，pairs 为 key, value 交替，不在路径中的作为查询参数
+//
+//	server.Get("/users/:id/files/*path", builder, "user.file")
+//	server.URL("user.file", "id", 5, "path", "a b/c.txt", "v", 2) // /users/5/files/a%20b/c.txt?v=2
+func (this *HttpServer) URL(name string, pairs ...interface{}) (string, error) {
+	this.routeLock.RLock()
+	pattern, has := this.routeNames[name]
+	this.routeLock.RUnlock()
+	if !has {
+		return "", Err_RouteNotFound
+	}
+	return buildURL(pattern, pairs...)
+}
+
+// 带协议和 Host 的完整地址，nameOrPath 以 / 开头时是路径，否则是路由名
+// 经过可信代理时使用代理转发的协议和 Host
+func (this *Handler) AbsoluteURL(nameOrPath string, pairs ...interface{}) (u string, e error) {
+	if strings.HasPrefix(nameOrPath, "/") {
+		u, e = buildURL(nameOrPath, pairs...)
+	} else {
+		u, e = this.getServer().URL(nameOrPath, pairs...)
+	}
+	if e == nil {
+		u = this.Scheme() + "://" + this.Host() + u
+	}
+	return
+}
+
+// 日志和统计中使用的路由名，没有名字时为 pattern
+func routeLabel(route *_Router) string {
+	if len(route.RouteNames) != 0 {
+		return route.RouteNames[0]
+	}
+	if len(route.Name) > 1 {
+		return strings.TrimSuffix(route.Name, "/")
+	}
+	return route.Name
+}
+
+type RouteInfo struct {
+	Pattern string
+	Methods []string
+	Names   []string
+}
+
+// 所有路由，按路径排序
+func (this *HttpServer) Routes() []RouteInfo {
+	this.routeLock.RLock()
+	defer this.routeLock.RUnlock()
+	routes := make([]RouteInfo, 0, len(this.routers))
+	for _, r := range this.routers {
+		info := RouteInfo{Pattern: r.Name, Names: append([]string{}, r.RouteNames...)}
+		if len(info.Pattern) > 1 {
+			info.Pattern = strings.TrimSuffix(info.Pattern, "/")
+		}
+		for method := range r.Methods {
+			info.Methods = append(info.Methods, method)
+		}
+		if r.Builder != nil {
+			if r.AnyMethods == nil {
+				info.Methods = append(info.Methods, "*")
+			} else {
+				info.Methods = append(info.Methods, r.AnyMethods...)
+			}
+		}
+		sort.Strings(info.Methods)
+		routes = append(routes, info)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Pattern < routes[j].Pattern
+	})
+	return routes
+}
+
+// 以表格输出路由，调试用
+func (this *HttpServer) DumpRoutes(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "METHODS\tPATTERN\tNAME")
+	for _, r := range this.Routes() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", strings.Join(r.Methods, ","), r.Pattern, strings.Join(r.Names, ","))
+	}
+	tw.Flush()
+}
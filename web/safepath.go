@@ -0,0 +1,91 @@
+package web
+
+import (
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	Err_UnsafePath = errors.New("Unsafe path")
+	Err_HiddenPath = errors.New("Hidden path")
+)
+
+// 把 URL 中的相对路径 rel 解析到 root 下，保证结果(包括软链接指向的位置)不会跑出 root
+// rel 中有 NUL、反斜杠、. 或 .. 时返回 Err_UnsafePath，allowHidden 为 false 时 . 开头的文件/目录返回 Err_HiddenPath
+// 文件不存在时返回拼接后的路径和 os.Stat 的错误
+func SafeJoin(root, rel string, allowHidden bool) (string, error) {
+	if strings.ContainsAny(rel, "\x00\\") {
+		return "", Err_UnsafePath
+	}
+	segs := []string{}
+	for _, seg := range strings.Split(rel, "/") {
+		switch {
+		case len(seg) == 0:
+			continue
+		case seg == "." || seg == "..":
+			return "", Err_UnsafePath
+		case seg[0] == '.' && !allowHidden:
+			return "", Err_HiddenPath
+		}
+		segs = append(segs, seg)
+	}
+
+	absRoot, e := filepath.Abs(root)
+	if e != nil {
+		return "", e
+	}
+	full := filepath.Join(append([]string{absRoot}, segs...)...)
+
+	// 软链接解析后仍然需要在 root 里面
+	realRoot, e := filepath.EvalSymlinks(absRoot)
+	if e != nil {
+		return full, e
+	}
+	real, e := filepath.EvalSymlinks(full)
+	if e != nil {
+		if _, statErr := os.Lstat(full); statErr == nil {
+			// 软链接指向不存在的文件
+			return "", Err_UnsafePath
+		}
+		return full, e
+	}
+	if !isSubPath(realRoot, real) {
+		return "", Err_UnsafePath
+	}
+	return full, nil
+}
+
+func isSubPath(root, p string) bool {
+	rel, e := filepath.Rel(root, p)
+	return e == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) && !filepath.IsAbs(rel)
+}
+
+// 原始 URL 中包含编码过的 / \ 或 NUL 时返回 Err_UnsafePath
+func checkEscapedPath(u *url.URL) error {
+	raw := strings.ToLower(u.EscapedPath())
+	for _, s := range []string{"%2f", "%5c", "%00"} {
+		if strings.Contains(raw, s) {
+			return Err_UnsafePath
+		}
+	}
+	return nil
+}
+
+// 按 SafeJoin 的规则把 rel 解析到 root 下，同时检查请求的 URL 中没有编码过的分隔符
+// 所有读取本地文件的 handler 都应该通过它得到文件路径
+func (this *Handler) SafeFilePath(root, rel string, allowHidden bool) (string, error) {
+	if e := checkEscapedPath(this.Request.URL); e != nil {
+		return "", e
+	}
+	return SafeJoin(root, rel, allowHidden)
+}
+
+// 拒绝访问 . 开头的文件和目录(例如 .git、.env)，返回 404
+func DenyHidden() StaticOption {
+	return func(router *staticRouter) {
+		router.DenyHidden = true
+	}
+}
@@ -0,0 +1,100 @@
+package web
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSafeJoin(t *testing.T) {
+	base := t.TempDir()
+	root, outside := filepath.Join(base, "root"), filepath.Join(base, "outside")
+	for _, dir := range []string{filepath.Join(root, "sub"), filepath.Join(root, ".git"), outside} {
+		if e := os.MkdirAll(dir, 0755); e != nil {
+			t.Fatal(e)
+		}
+	}
+	os.WriteFile(filepath.Join(root, "sub", "a.txt"), []byte("a"), 0644)
+	os.WriteFile(filepath.Join(root, ".git", "config"), []byte("c"), 0644)
+	os.WriteFile(filepath.Join(outside, "secret"), []byte("s"), 0644)
+	links := map[string]string{
+		"escape":      outside,
+		"escape.txt":  filepath.Join(outside, "secret"),
+		"relative":    "../outside",
+		"inside":      "sub",
+		"dangling":    filepath.Join(base, "missing"),
+		"sub/up.txt":  "../sub/a.txt",
+		"sub/parent":  "..",
+		"sub/outside": "../../outside",
+	}
+	for name, target := range links {
+		if e := os.Symlink(target, filepath.Join(root, name)); e != nil {
+			t.Skip("symlink not supported:", e)
+		}
+	}
+
+	cases := []struct {
+		rel         string
+		allowHidden bool
+		path        string
+		err         error
+	}{
+		{"sub/a.txt", false, "sub/a.txt", nil},
+		{"/sub//a.txt", false, "sub/a.txt", nil},
+		{"", false, "", nil},
+		{"..", true, "", Err_UnsafePath},
+		{"sub/../../outside/secret", true, "", Err_UnsafePath},
+		{"sub/../sub/a.txt", true, "", Err_UnsafePath},
+		{"./sub", true, "", Err_UnsafePath},
+		{"sub\\..\\..\\outside", true, "", Err_UnsafePath},
+		{"sub/a.txt\x00.png", true, "", Err_UnsafePath},
+		{".git/config", false, "", Err_HiddenPath},
+		{"sub/.hidden", false, "", Err_HiddenPath},
+		{".git/config", true, ".git/config", nil},
+		{"escape/secret", true, "", Err_UnsafePath},
+		{"escape.txt", true, "", Err_UnsafePath},
+		{"relative/secret", true, "", Err_UnsafePath},
+		{"sub/outside/secret", true, "", Err_UnsafePath},
+		{"dangling", true, "", Err_UnsafePath},
+		{"inside/a.txt", true, "inside/a.txt", nil},
+		{"sub/up.txt", true, "sub/up.txt", nil},
+		{"sub/parent/sub/a.txt", true, "sub/parent/sub/a.txt", nil},
+	}
+	absRoot, _ := filepath.Abs(root)
+	for _, c := range cases {
+		p, e := SafeJoin(root, c.rel, c.allowHidden)
+		expect := ""
+		if c.err == nil {
+			expect = filepath.Join(absRoot, filepath.FromSlash(c.path))
+		}
+		if e != c.err || p != expect {
+			t.Errorf("%q: got %q %v, expect %q %v", c.rel, p, e, expect, c.err)
+		}
+	}
+
+	// 不存在的文件返回路径和 os.Stat 的错误
+	if p, e := SafeJoin(root, "sub/none.txt", false); !os.IsNotExist(e) || p != filepath.Join(absRoot, "sub", "none.txt") {
+		t.Errorf("missing file: %q %v", p, e)
+	}
+}
+
+func TestCheckEscapedPath(t *testing.T) {
+	cases := map[string]error{
+		"/static/a.txt":        nil,
+		"/static/a%20b.txt":    nil,
+		"/static/..%2fsecret":  Err_UnsafePath,
+		"/static/..%2Fsecret":  Err_UnsafePath,
+		"/static/..%5csecret":  Err_UnsafePath,
+		"/static/a.txt%00.png": Err_UnsafePath,
+	}
+	for raw, expect := range cases {
+		u, e := url.Parse(raw)
+		if e != nil {
+			t.Fatal(e)
+		}
+		if e = checkEscapedPath(u); e != expect {
+			t.Errorf("%s: got %v", raw, e)
+		}
+	}
+}
@@ -50,6 +50,11 @@ func Persistence(filePath string) {
 }
 
 func init() {
+	web.RegisterGauge("web_sessions", "Sessions in memory.", func() float64 {
+		sessionLock.RLock()
+		defer sessionLock.RUnlock()
+		return float64(len(sessions))
+	})
 	rand.Seed(time.Now().Unix())
 	go func() {
 		span := time.Second * 60
@@ -81,6 +86,13 @@ func init() {
 	}()
 }
 
+// 按 session 限流，没有 session 时按 IP
+//
+//	web.NewSlidingWindow(60, time.Minute, session.RateLimitKey)
+func RateLimitKey(handler web.IHandler) string {
+	return web.KeyByCookie(Key)(handler)
+}
+
 type Session struct {
 	Value             interface{}
 	Expires           time.Time
@@ -0,0 +1,237 @@
+package web
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"zwei.ren/log"
+)
+
+var (
+	// HttpServer.DrainTimeout 为0时使用
+	DefaultDrainTimeout = time.Second * 30
+
+	Err_NotRunning   = errors.New("Not a running server")
+	Err_ShuttingDown = errors.New("Server is shutting down")
+)
+
+// 被 Hijack 的连接(websocket 等)，http.Server.Shutdown 不会等待和关闭它们
+type hijackedConn struct {
+	conn       net.Conn
+	onShutdown []func()
+}
+
+type drainState struct {
+	lock      sync.Mutex
+	hooks     []func(ctx context.Context)
+	conns     map[*hijackedConn]struct{}
+	idle      chan struct{} // 所有 hijacked 连接都结束时关闭
+	stopping  chan struct{} // 开始关闭时关闭
+	redirects map[*http.Server]struct{}
+	draining  int32
+}
+
+// 开始关闭时关闭的 channel，长连接(SSE 等)可以据此主动结束
+func (this *HttpServer) Stopping() <-chan struct{} {
+	this.drain.lock.Lock()
+	defer this.drain.lock.Unlock()
+	if atomic.LoadInt32(&this.drain.draining) == 1 {
+		stopped := make(chan struct{})
+		close(stopped)
+		return stopped
+	}
+	if this.drain.stopping == nil {
+		this.drain.stopping = make(chan struct{})
+	}
+	return this.drain.stopping
+}
+
+// 开始关闭时调用，在等待请求结束之前按顺序执行
+func OnShutdown(hooks ...func(ctx context.Context)) {
+	DefaultServer.OnShutdown(hooks...)
+}
+
+func (this *HttpServer) OnShutdown(hooks ...func(ctx context.Context)) {
+	this.drain.lock.Lock()
+	defer this.drain.lock.Unlock()
+	this.drain.hooks = append(this.drain.hooks, hooks...)
+}
+
+func (this *HttpServer) trackConn(conn net.Conn) *hijackedConn {
+	c := &hijackedConn{conn: conn}
+	this.drain.lock.Lock()
+	defer this.drain.lock.Unlock()
+	if this.drain.conns == nil {
+		this.drain.conns = map[*hijackedConn]struct{}{}
+	}
+	this.drain.conns[c] = struct{}{}
+	return c
+}
+
+func (this *HttpServer) untrackConn(c *hijackedConn) {
+	this.drain.lock.Lock()
+	defer this.drain.lock.Unlock()
+	delete(this.drain.conns, c)
+	if len(this.drain.conns) == 0 && this.drain.idle != nil {
+		close(this.drain.idle)
+		this.drain.idle = nil
+	}
+}
+
+// Hijack 之后调用，服务器关闭时执行 fn(例如发送 websocket 的 close 帧)，
+// 之后等待 handler 返回，超过 DrainTimeout 时直接断开连接
+func (this *Handler) OnServerShutdown(fn func()) {
+	if w, is := this.Writer.(*responseWriter); is && w.tracked != nil && w.server != nil {
+		w.server.drain.lock.Lock()
+		w.tracked.onShutdown = append(w.tracked.onShutdown, fn)
+		draining := atomic.LoadInt32(&w.server.drain.draining) == 1
+		w.server.drain.lock.Unlock()
+		if draining {
+			go fn()
+		}
+	}
+}
+
+// 正在关闭时返回 false
+func (this *HttpServer) Ready() bool {
+	return atomic.LoadInt32(&this.drain.draining) == 0
+}
+
+// 健康检查，正常时回复 200，关闭过程中回复 503，让负载均衡停止转发
+func AddHealthRouter(httpPath string) {
+	DefaultServer.AddHealthRouter(httpPath)
+}
+
+func (this *HttpServer) AddHealthRouter(httpPath string) {
+	this.AddRouter(httpPath, func() IHandler {
+		return &healthHandler{server: this}
+	})
+}
+
+type healthHandler struct {
+	Handler
+	server *HttpServer
+}
+
+func (this *healthHandler) Handle() {
+	this.ResponseHeader("Content-Type", "application/json")
+	this.ResponseHeader("Cache-Control", "no-store")
+	if this.server.Ready() {
+		this.ResponseOK()
+		this.ResponseData(`{"status":"ok"}`)
+	} else {
+		this.ResponseStatus(http.StatusServiceUnavailable)
+		this.ResponseData(`{"status":"draining"}`)
+	}
+}
+
+// 平滑关闭：标记为不可用，等待 ShutdownDelay，执行 OnShutdown，通知 hijacked 连接，然后等待所有请求结束，
+// ctx 结束时强制断开剩下的连接并返回 ctx.Err()，关闭之后 Ready() 一直返回 false
+func Shutdown(ctx context.Context) error {
+	return DefaultServer.Shutdown(ctx)
+}
+
+func (this *HttpServer) Shutdown(ctx context.Context) (e error) {
+	this.serverLock.Lock()
+	servers := make([]*http.Server, 0, len(this.servers))
+	for server := range this.servers {
+		servers = append(servers, server)
+	}
+	if len(servers) == 0 {
+		this.serverLock.Unlock()
+		return Err_NotRunning
+	}
+	if !atomic.CompareAndSwapInt32(&this.drain.draining, 0, 1) {
+		this.serverLock.Unlock()
+		return Err_ShuttingDown
+	}
+	this.serverLock.Unlock()
+
+	if this.ShutdownDelay > 0 {
+		select {
+		case <-time.After(this.ShutdownDelay):
+		case <-ctx.Done():
+		}
+	}
+
+	this.drain.lock.Lock()
+	hooks := append([]func(ctx context.Context){}, this.drain.hooks...)
+	redirects := make([]*http.Server, 0, len(this.drain.redirects))
+	for redirect := range this.drain.redirects {
+		redirects = append(redirects, redirect)
+	}
+	if this.drain.stopping != nil {
+		close(this.drain.stopping)
+		this.drain.stopping = nil
+	}
+	this.drain.lock.Unlock()
+	for _, hook := range hooks {
+		func() {
+			defer HandleException("OnShutdown")
+			hook(ctx)
+		}()
+	}
+	for _, redirect := range redirects {
+		redirect.Shutdown(ctx)
+	}
+
+	this.drain.lock.Lock()
+	var idle chan struct{}
+	if len(this.drain.conns) != 0 {
+		idle = make(chan struct{})
+		this.drain.idle = idle
+	}
+	for c := range this.drain.conns {
+		for _, fn := range c.onShutdown {
+			go func(fn func()) {
+				defer HandleException("OnServerShutdown")
+				fn()
+			}(fn)
+		}
+	}
+	this.drain.lock.Unlock()
+
+	// 同时关闭所有监听，等待各自的请求结束
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func(server *http.Server) {
+			errs <- server.Shutdown(ctx)
+		}(server)
+	}
+	for range servers {
+		if err := <-errs; err != nil && e == nil {
+			e = err
+		}
+	}
+	if idle != nil {
+		select {
+		case <-idle:
+		case <-ctx.Done():
+			this.drain.lock.Lock()
+			for c := range this.drain.conns {
+				c.conn.Close()
+			}
+			this.drain.lock.Unlock()
+			if e == nil {
+				e = ctx.Err()
+			}
+		}
+	}
+	if e != nil {
+		for _, server := range servers {
+			server.Close()
+		}
+		log.Error("Server shutdown not clean: %v", e)
+	}
+	this.serverLock.Lock()
+	for _, server := range servers {
+		delete(this.servers, server)
+	}
+	this.serverLock.Unlock()
+	return
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	ws "github.com/gorilla/websocket"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	"zwei.ren/web"
 )
@@ -22,6 +24,20 @@ const (
 
 var Err_CloseIntent = errors.New("Close by you")
 
+var connections, totalConnections, totalMessages int64
+
+func init() {
+	web.RegisterGauge("websocket_connections", "Open WebSocket connections.", func() float64 {
+		return float64(atomic.LoadInt64(&connections))
+	})
+	web.RegisterCounter("websocket_connections_total", "WebSocket connections accepted.", func() float64 {
+		return float64(atomic.LoadInt64(&totalConnections))
+	})
+	web.RegisterCounter("websocket_messages_received_total", "WebSocket messages received.", func() float64 {
+		return float64(atomic.LoadInt64(&totalMessages))
+	})
+}
+
 type IHandler interface {
 	web.IHandler
 	setSelf(IHandler)
@@ -75,6 +91,12 @@ func (this *Handler) ResponseHeaders(headers map[string][]string) {
 		this.headers = http.Header(headers)
 	}
 }
+func (this *Handler) ResponseHeader(key string, values ...string) {
+	if this.headers == nil {
+		this.headers = http.Header{}
+	}
+	this.headers[key] = values
+}
 func (this *Handler) Send(msgType int, body []byte) error {
 	return this.Conn.WriteMessage(msgType, body)
 }
@@ -91,6 +113,9 @@ func (this *Handler) Handle() {
 	WebsocketUpgrader := ws.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
 	if conn, e := WebsocketUpgrader.Upgrade(this.Writer, this.Request, this.headers); e == nil {
 		defer conn.Close()
+		atomic.AddInt64(&totalConnections, 1)
+		atomic.AddInt64(&connections, 1)
+		defer atomic.AddInt64(&connections, -1)
 		conn.SetCloseHandler(func(code int, text string) error {
 			this.dispatchDisconnect(errors.New(fmt.Sprintf("%d-%s", code, text)))
 			return nil
@@ -112,6 +137,10 @@ func (this *Handler) Handle() {
 		}()
 
 		this.Conn = conn
+		// 服务器关闭时通知客户端，客户端回复 close 帧后 ReadMessage 返回错误
+		this.OnServerShutdown(func() {
+			conn.WriteControl(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseGoingAway, "server shutdown"), time.Now().Add(time.Second))
+		})
 		go func() { // 这个放在其它协程
 			defer web.HandleException(this.Request.URL.Path)
 			this.iHandler.OnConnect()
@@ -123,6 +152,7 @@ func (this *Handler) Handle() {
 		onMsg := this.iHandler.OnMessage
 		for {
 			if msgType, msgBytes, e = conn.ReadMessage(); e == nil {
+				atomic.AddInt64(&totalMessages, 1)
 				e = onMsg(msgType, msgBytes)
 			}
 			if e != nil {
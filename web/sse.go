@@ -0,0 +1,263 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// SSE 默认的心跳间隔，<1 时不发送
+	SSEHeartbeat = time.Second * 15
+
+	Err_ClientGone       = errors.New("Client disconnected")
+	Err_StreamClosed     = errors.New("Stream closed")
+	Err_FlushUnsupported = errors.New("ResponseWriter does not support flush")
+)
+
+// 直接写出响应头，之后由 handler 自己写内容
+func (this *Handler) startStream(contentType string) (http.Flusher, error) {
+	flusher, is := this.Writer.(http.Flusher)
+	if !is {
+		return nil, Err_FlushUnsupported
+	}
+	this.NoResponse()
+	header := this.Writer.Header()
+	for k, vs := range this.ResHeaders {
+		for _, v := range vs {
+			header.Add(k, v)
+		}
+	}
+	header.Set("Content-Type", contentType)
+	header.Del("Content-Length")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	// 默认的 404 表示还没有设置过状态码，这时回复 200
+	status := this.ResCode
+	if status < 200 || status == http.StatusNotFound && !this.statusSet {
+		status = http.StatusOK
+	}
+	this.Writer.WriteHeader(status)
+	flusher.Flush()
+	if w := unwrapWriter(this.Writer); w != nil {
+		w.detach()
+	}
+	return flusher, nil
+}
+
+type Event struct {
+	ID    string
+	Event string
+	Data  interface{} // string/[]byte 原样输出，其它转成 json
+	Retry time.Duration
+}
+
+// Server-Sent Events 的写入器，所有方法都可以在多个协程中调用
+type EventStream struct {
+	request   *http.Request
+	stopping  <-chan struct{}
+	writer    http.ResponseWriter
+	flusher   http.Flusher
+	lock      sync.Mutex
+	closed    bool
+	closing   chan struct{} // Close() 时关闭
+	done      chan struct{} // 第一次调用 Done() 时创建
+	heartbeat chan struct{} // 关闭时停止当前的心跳
+}
+
+// 开始 SSE 输出，客户端断开后 Done() 会关闭，Send() 返回 Err_ClientGone
+// handler 返回时 stream 会自动 Close()，不能在 handler 之外继续使用
+//
+//	stream, e := this.SSE()
+//	for e == nil {
+//		select {
+//		case msg := <-messages:
+//			e = stream.Send(&web.Event{Event: "message", Data: msg})
+//		case <-stream.Done():
+//			return
+//		}
+//	}
+func (this *Handler) SSE() (stream *EventStream, e error) {
+	var flusher http.Flusher
+	if flusher, e = this.startStream("text/event-stream"); e == nil {
+		stream = &EventStream{
+			request:  this.Request,
+			stopping: this.getServer().Stopping(),
+			writer:   this.Writer,
+			flusher:  flusher,
+			closing:  make(chan struct{}),
+		}
+		this.streams = append(this.streams, stream)
+		if SSEHeartbeat > 0 {
+			stream.Heartbeat(SSEHeartbeat)
+		}
+	}
+	return
+}
+
+// 客户端断开、Close() 或者服务器开始关闭后关闭，多次调用返回同一个 chan
+func (this *EventStream) Done() <-chan struct{} {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.done == nil {
+		this.done = make(chan struct{})
+		go func() {
+			select {
+			case <-this.request.Context().Done():
+			case <-this.closing:
+			case <-this.stopping:
+			}
+			close(this.done)
+		}()
+	}
+	return this.done
+}
+
+// 定时发送注释行保持连接，重复调用会替换之前的间隔，<1 时停止心跳
+func (this *EventStream) Heartbeat(interval time.Duration) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return
+	}
+	if this.heartbeat != nil {
+		close(this.heartbeat)
+		this.heartbeat = nil
+	}
+	if interval < 1 {
+		return
+	}
+	this.heartbeat = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if this.Comment("ping") != nil {
+					return
+				}
+			case <-stop:
+				return
+			case <-this.closing:
+				return
+			case <-this.request.Context().Done():
+				return
+			case <-this.stopping:
+				return
+			}
+		}
+	}(this.heartbeat)
+}
+
+func (this *EventStream) write(msg string) error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.closed {
+		return Err_StreamClosed
+	}
+	if this.request.Context().Err() != nil {
+		return Err_ClientGone
+	}
+	if _, e := this.writer.Write([]byte(msg)); e != nil {
+		return e
+	}
+	this.flusher.Flush()
+	return nil
+}
+
+func (this *EventStream) Send(event *Event) error {
+	buff := strings.Builder{}
+	if len(event.ID) != 0 {
+		buff.WriteString("id: " + stripNewlines(event.ID) + "\n")
+	}
+	if len(event.Event) != 0 {
+		buff.WriteString("event: " + stripNewlines(event.Event) + "\n")
+	}
+	if event.Retry > 0 {
+		buff.WriteString("retry: " + strconv.FormatInt(int64(event.Retry/time.Millisecond), 10) + "\n")
+	}
+	var data string
+	switch v := event.Data.(type) {
+	case nil:
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		bs, e := json.Marshal(v)
+		if e != nil {
+			return e
+		}
+		data = string(bs)
+	}
+	for _, line := range strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n") {
+		buff.WriteString("data: " + line + "\n")
+	}
+	buff.WriteString("\n")
+	return this.write(buff.String())
+}
+
+func (this *EventStream) SendData(data interface{}) error {
+	return this.Send(&Event{Data: data})
+}
+
+// 注释行，客户端会忽略
+func (this *EventStream) Comment(text string) error {
+	return this.write(": " + stripNewlines(text) + "\n\n")
+}
+
+// 停止心跳，之后的写入都会失败，handler 返回后连接会被关闭
+func (this *EventStream) Close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if !this.closed {
+		this.closed = true
+		close(this.closing)
+	}
+}
+
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
+
+// 每次 Write 都会立即 flush 的分块输出
+type ChunkedWriter struct {
+	request *http.Request
+	writer  http.ResponseWriter
+	flusher http.Flusher
+}
+
+// 开始分块输出，contentType 为空时使用 application/octet-stream
+func (this *Handler) Chunked(contentType string) (writer *ChunkedWriter, e error) {
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	var flusher http.Flusher
+	if flusher, e = this.startStream(contentType); e == nil {
+		writer = &ChunkedWriter{request: this.Request, writer: this.Writer, flusher: flusher}
+	}
+	return
+}
+
+func (this *ChunkedWriter) Write(bs []byte) (n int, e error) {
+	if this.request.Context().Err() != nil {
+		return 0, Err_ClientGone
+	}
+	if n, e = this.writer.Write(bs); e == nil {
+		this.flusher.Flush()
+	}
+	return
+}
+
+func (this *ChunkedWriter) WriteString(s string) (int, error) {
+	return this.Write([]byte(s))
+}
+
+func (this *ChunkedWriter) Done() <-chan struct{} {
+	return this.request.Context().Done()
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type streamHandler struct {
+	Handler
+	stream *EventStream
+}
+
+func (this *streamHandler) Handle() {
+	if this.GetGetParam("missing") == "1" {
+		this.ResponseStatus(http.StatusNotFound)
+	}
+	stream, e := this.SSE()
+	if e != nil {
+		panic(e)
+	}
+	this.stream = stream
+	if stream.Done() != stream.Done() {
+		panic("Done() returns different chans")
+	}
+	stream.Heartbeat(time.Millisecond)
+	stream.SendData("hello")
+	select {
+	case <-stream.Done():
+		panic("heartbeat closed Done()")
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestSSE(t *testing.T) {
+	var handler *streamHandler
+	server := &HttpServer{}
+	server.AddRouter("/events", func() IHandler {
+		handler = new(streamHandler)
+		return handler
+	})
+
+	rec := httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/events", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "text/event-stream" || !strings.Contains(rec.Body.String(), "data: hello\n\n") {
+		t.Fatal(rec.Code, rec.Header(), rec.Body.String())
+	}
+	select {
+	case <-handler.stream.Done():
+	case <-time.After(time.Second):
+		t.Fatal("stream not closed after the handler returned")
+	}
+	if e := handler.stream.SendData("late"); e != Err_StreamClosed {
+		t.Fatal(e)
+	}
+
+	rec = httptest.NewRecorder()
+	server.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/events?missing=1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatal(rec.Code)
+	}
+}
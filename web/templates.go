@@ -0,0 +1,524 @@
+package web
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"zwei.ren/encrypt"
+	"zwei.ren/log"
+)
+
+var (
+	// 没有调用 SetTplDir 时使用的模板目录
+	DefaultTplDir = "./views"
+	// 作为模板加载的文件后缀
+	TemplateExts = []string{".html", ".tpl", ".tmpl"}
+	// 开发模式下检查模板文件变化的间隔
+	TemplateWatchInterval = time.Second
+
+	Err_TemplateNotFound = errors.New("Template not found")
+
+	templateFuncLock sync.RWMutex
+	templateFuncs    = template.FuncMap{}
+
+	// {{csrf}} 先输出这个占位符，Handler.Tpl 渲染后再换成这次请求的 token，不用每次都 Clone 模板
+	// 随机生成，不能被猜到，否则模板数据中的占位符也会被替换成 token
+	csrfPlaceholder = func() string {
+		bs := make([]byte, 16)
+		rand.Read(bs)
+		return "csrf" + hex.EncodeToString(bs)
+	}()
+)
+
+// 注册所有模板都可以使用的函数，需要在模板加载之前调用
+func RegisterTemplateFunc(name string, fn interface{}) {
+	templateFuncLock.Lock()
+	defer templateFuncLock.Unlock()
+	templateFuncs[name] = fn
+}
+
+// 一个目录下的模板
+//
+// LayoutDir 和 PartialDir 下的文件是公共的，其它文件各自是一个页面，以相对 Dir 的路径命名，
+// 每个页面都可以使用所有的 layout/partial，并且可以用 {{define}} 覆盖其中的 {{block}}：
+//
+//	layouts/base.html:  <html><body>{{block "content" .}}{{end}}</body></html>
+//	user/info.html:     {{template "layouts/base.html" .}}{{define "content"}}{{.Name}}{{end}}
+//
+// 文件名不重复时也可以只用文件名引用，例如 {{template "base.html" .}}
+//
+// 内置函数：
+//
+//	{{url "/users/:id" "id" 1 "tab" "info"}}  => /users/1?tab=info
+//	{{url "user.info" "id" 1}}                => 按路由名生成，见 HttpServer.URL
+//	{{asset "/static/app.js"}}                => /static/app.js?v=5d41402a
+//	{{csrf}} {{csrfField}}                    => 通过 Handler.Tpl 渲染时可用
+type Templates struct {
+	Dir        string
+	LayoutDir  string // 相对 Dir，默认 layouts
+	PartialDir string // 相对 Dir，默认 partials
+	DevMode    bool   // 文件变化后自动重新加载
+
+	lock         sync.RWMutex
+	funcs        template.FuncMap
+	views        map[string]*template.Template                 // 用于 Clone，不能直接执行
+	runs         map[string]*template.Template                 // {{url}} 按 DefaultServer 的路由名生成
+	bound        map[*HttpServer]map[string]*template.Template // 其它服务器各自的 {{url}}，第一次渲染时 Clone
+	loaded       bool
+	stamp        string
+	watching     bool
+	stop         chan struct{}
+	assets       map[string]string
+	fingerprints map[string]*assetFingerprint
+}
+
+type assetFingerprint struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+func NewTemplates(dir string) *Templates {
+	return &Templates{
+		Dir:          dir,
+		LayoutDir:    "layouts",
+		PartialDir:   "partials",
+		funcs:        template.FuncMap{},
+		assets:       map[string]string{},
+		fingerprints: map[string]*assetFingerprint{},
+	}
+}
+
+// 添加只对这组模板生效的函数，已经加载过时会在下次渲染前重新加载
+func (this *Templates) Funcs(funcs template.FuncMap) *Templates {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for name, fn := range funcs {
+		this.funcs[name] = fn
+	}
+	this.loaded = false
+	return this
+}
+
+// {{asset}} 中以 urlPrefix 开头的路径对应 folder 下的文件
+func (this *Templates) AddAssetDir(urlPrefix, folder string) *Templates {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.assets[urlPrefix] = folder
+	return this
+}
+
+func (this *Templates) isTemplate(name string) bool {
+	ext := path.Ext(name)
+	for _, e := range TemplateExts {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// 遍历所有模板文件，返回相对 Dir 的路径(使用 /)
+func (this *Templates) files() (names []string, stamp string, e error) {
+	var count, size int64
+	var latest time.Time
+	e = filepath.Walk(this.Dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !this.isTemplate(info.Name()) {
+			return nil
+		}
+		rel, _ := filepath.Rel(this.Dir, p)
+		names = append(names, filepath.ToSlash(rel))
+		count++
+		size += info.Size()
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	stamp = fmt.Sprintf("%d-%d-%d", count, size, latest.UnixNano())
+	return
+}
+
+// 解析目录下所有模板，DevMode 时开始监听文件变化
+func (this *Templates) Load() error {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.load()
+}
+
+func (this *Templates) load() error {
+	names, stamp, e := this.files()
+	if e != nil {
+		return e
+	}
+
+	funcs := template.FuncMap{
+		"url":       urlFunc(DefaultServer),
+		"asset":     this.Asset,
+		"csrf":      func() string { return csrfPlaceholder },
+		"csrfField": func() template.HTML { return csrfInput(csrfPlaceholder) },
+	}
+	templateFuncLock.RLock()
+	for name, fn := range templateFuncs {
+		funcs[name] = fn
+	}
+	templateFuncLock.RUnlock()
+	for name, fn := range this.funcs {
+		funcs[name] = fn
+	}
+
+	isShared := func(name string) bool {
+		for _, dir := range []string{this.LayoutDir, this.PartialDir} {
+			if len(dir) != 0 && strings.HasPrefix(name, strings.Trim(dir, "/")+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	// 旧版本按文件名引用模板，例如 {{template "header.tpl"}}，文件名不重复时继续支持
+	baseNames := map[string]int{}
+	for _, name := range names {
+		baseNames[path.Base(name)]++
+	}
+	alias := func(tpl *template.Template, name string) (e error) {
+		if baseName := path.Base(name); baseName != name && baseNames[baseName] == 1 {
+			_, e = tpl.AddParseTree(baseName, tpl.Lookup(name).Tree)
+		}
+		return
+	}
+
+	base := template.New("").Funcs(funcs)
+	for _, name := range names {
+		if isShared(name) {
+			if e = parseTemplateFile(base.New(name), this.Dir, name); e != nil {
+				return e
+			}
+			if e = alias(base, name); e != nil {
+				return e
+			}
+		}
+	}
+	views, runs := map[string]*template.Template{}, map[string]*template.Template{}
+	for _, name := range names {
+		if isShared(name) {
+			continue
+		}
+		var view *template.Template
+		if view, e = base.Clone(); e != nil {
+			return e
+		}
+		if e = parseTemplateFile(view.New(name), this.Dir, name); e != nil {
+			return e
+		}
+		if e = alias(view, name); e != nil {
+			return e
+		}
+		views[name] = view
+		if runs[name], e = view.Clone(); e != nil {
+			return e
+		}
+	}
+
+	this.views, this.runs, this.stamp, this.loaded = views, runs, stamp, true
+	this.bound = map[*HttpServer]map[string]*template.Template{}
+	if this.DevMode && !this.watching {
+		this.watching = true
+		this.stop = make(chan struct{})
+		go this.watch(this.stop)
+	}
+	return nil
+}
+
+func parseTemplateFile(tpl *template.Template, dir, name string) error {
+	bs, e := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if e == nil {
+		_, e = tpl.Parse(string(bs))
+	}
+	return e
+}
+
+func (this *Templates) watch(stop chan struct{}) {
+	ticker := time.NewTicker(TemplateWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			_, stamp, e := this.files()
+			this.lock.RLock()
+			changed := e == nil && stamp != this.stamp
+			this.lock.RUnlock()
+			if changed {
+				if e = this.Load(); e == nil {
+					log.Info("Templates in %s reloaded", this.Dir)
+				} else {
+					log.Error("Reload templates in %s failed: %v", this.Dir, e)
+				}
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// 停止监听文件变化
+func (this *Templates) Close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.watching {
+		this.watching = false
+		close(this.stop)
+	}
+}
+
+// 返回页面模板，clone 为 true 时返回可以 Clone 的版本
+func (this *Templates) lookup(name string, clone bool) (view *template.Template, e error) {
+	this.lock.RLock()
+	loaded := this.loaded
+	this.lock.RUnlock()
+	if !loaded {
+		this.lock.Lock()
+		if !this.loaded {
+			e = this.load()
+		}
+		this.lock.Unlock()
+	}
+	this.lock.RLock()
+	if clone {
+		view = this.views[name]
+	} else {
+		view = this.runs[name]
+	}
+	this.lock.RUnlock()
+	if e == nil && view == nil {
+		e = Err_TemplateNotFound
+	}
+	return
+}
+
+// server 的 {{url}} 使用自己的路由名，同一个 Templates 可以给多个服务器使用
+func (this *Templates) lookupFor(server *HttpServer, name string) (view *template.Template, e error) {
+	if server == nil || server == DefaultServer {
+		return this.lookup(name, false)
+	}
+	if view, e = this.lookup(name, true); e != nil {
+		return
+	}
+	this.lock.RLock()
+	bound := this.bound[server][name]
+	this.lock.RUnlock()
+	if bound != nil {
+		return bound, nil
+	}
+
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.bound[server] == nil {
+		this.bound[server] = map[string]*template.Template{}
+	}
+	if bound = this.bound[server][name]; bound == nil {
+		if bound, e = view.Clone(); e != nil {
+			return nil, e
+		}
+		bound.Funcs(template.FuncMap{"url": urlFunc(server)})
+		this.bound[server][name] = bound
+	}
+	return bound, nil
+}
+
+// 渲染页面 name，funcs 会覆盖同名的函数，extras 中的页面会一起解析进来
+// 不是通过 Handler.Tpl 渲染时 {{csrf}} 为空
+func (this *Templates) Execute(w io.Writer, name string, data interface{}, funcs template.FuncMap, extras ...string) error {
+	buff := bytes.NewBuffer([]byte{})
+	e := this.execute(buff, nil, name, data, funcs, extras...)
+	if e == nil {
+		_, e = w.Write(bytes.Replace(buff.Bytes(), []byte(csrfPlaceholder), nil, -1))
+	}
+	return e
+}
+
+// 只有 funcs 或 extras 不为空时才需要 Clone，server 为nil时使用 DefaultServer
+func (this *Templates) execute(w io.Writer, server *HttpServer, name string, data interface{}, funcs template.FuncMap, extras ...string) error {
+	if len(funcs) == 0 && len(extras) == 0 {
+		view, e := this.lookupFor(server, name)
+		if e == nil {
+			e = view.ExecuteTemplate(w, name, data)
+		}
+		return e
+	}
+
+	view, e := this.lookup(name, true)
+	if e == nil {
+		view, e = view.Clone()
+	}
+	if e != nil {
+		return e
+	}
+	if server != nil && server != DefaultServer {
+		view.Funcs(template.FuncMap{"url": urlFunc(server)})
+	}
+	if len(funcs) != 0 {
+		view.Funcs(funcs)
+	}
+	for _, extra := range extras {
+		var sub *template.Template
+		if sub, e = this.lookup(extra, true); e != nil {
+			return e
+		}
+		for _, t := range sub.Templates() {
+			if len(t.Name()) != 0 && t.Tree != nil && view.Lookup(t.Name()) == nil {
+				if _, e = view.AddParseTree(t.Name(), t.Tree); e != nil {
+					return e
+				}
+			}
+		}
+	}
+	return view.ExecuteTemplate(w, name, data)
+}
+
+func (this *Templates) Render(w io.Writer, name string, data interface{}) error {
+	return this.Execute(w, name, data, nil)
+}
+
+// 在资源路径后加上内容的 hash，文件不存在时原样返回
+func (this *Templates) Asset(p string) string {
+	this.lock.RLock()
+	var filePath string
+	for prefix, folder := range this.assets {
+		if strings.HasPrefix(p, prefix) {
+			filePath, _ = SafeJoin(folder, strings.TrimPrefix(p, prefix), false)
+			break
+		}
+	}
+	this.lock.RUnlock()
+	if len(filePath) == 0 {
+		return p
+	}
+	info, e := os.Stat(filePath)
+	if e != nil {
+		return p
+	}
+
+	this.lock.RLock()
+	fp := this.fingerprints[filePath]
+	this.lock.RUnlock()
+	if fp == nil || !fp.modTime.Equal(info.ModTime()) || fp.size != info.Size() {
+		var file *os.File
+		if file, e = os.Open(filePath); e != nil {
+			return p
+		}
+		fp = &assetFingerprint{modTime: info.ModTime(), size: info.Size(), hash: hex.EncodeToString(encrypt.MD5IO(file))[:8]}
+		file.Close()
+		this.lock.Lock()
+		this.fingerprints[filePath] = fp
+		this.lock.Unlock()
+	}
+	if strings.Contains(p, "?") {
+		return p + "&v=" + fp.hash
+	}
+	return p + "?v=" + fp.hash
+}
+
+// 用 pairs 中的值替换 pattern 中的 :name/*name，剩下的作为查询参数
+func buildURL(pattern string, pairs ...interface{}) (string, error) {
+	values := map[string]string{}
+	keys := []string{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key := fmt.Sprint(pairs[i])
+		if _, has := values[key]; !has {
+			keys = append(keys, key)
+		}
+		values[key] = fmt.Sprint(pairs[i+1])
+	}
+
+	segs := strings.Split(pattern, "/")
+	for i, seg := range segs {
+		if len(seg) > 1 && (seg[0] == ':' || seg[0] == '*') {
+			name := seg[1:]
+			value, has := values[name]
+			if !has && seg[0] == ':' {
+				return "", errors.New("Missing route param '" + name + "' for " + pattern)
+			}
+			delete(values, name)
+			if seg[0] == '*' {
+				parts := strings.Split(strings.TrimPrefix(value, "/"), "/")
+				for j := range parts {
+					parts[j] = escapeURL(parts[j])
+				}
+				segs[i] = strings.Join(parts, "/")
+			} else {
+				segs[i] = escapeURL(value)
+			}
+		}
+	}
+	u := strings.Join(segs, "/")
+
+	query := []string{}
+	for _, key := range keys {
+		if value, has := values[key]; has {
+			query = append(query, escapeURL(key)+"="+escapeURL(value))
+		}
+	}
+	if len(query) != 0 {
+		u += "?" + strings.Join(query, "&")
+	}
+	return u, nil
+}
+
+// URLEncode 后把空格的 + 换成 %20，路径和查询参数都可以用
+func escapeURL(s string) string {
+	return strings.Replace(URLEncode(s), "+", "%20", -1)
+}
+
+// {{url}}，以 / 开头时是路径模式，否则是 server 中的路由名
+func urlFunc(server *HttpServer) func(nameOrPattern string, pairs ...interface{}) (string, error) {
+	return func(nameOrPattern string, pairs ...interface{}) (string, error) {
+		if strings.HasPrefix(nameOrPattern, "/") {
+			return buildURL(nameOrPattern, pairs...)
+		}
+		return server.URL(nameOrPattern, pairs...)
+	}
+}
+
+// 渲染当前服务器的模板，文件名相对于模板目录，subTpls 中的模板会一起解析进来
+func (this *Handler) Tpl(fileName string, datas interface{}, subTpls ...string) (e error) {
+	buff, server := bytes.NewBuffer([]byte{}), this.getServer()
+	if e = server.templates().execute(buff, server, fileName, datas, nil, subTpls...); e == nil {
+		bs := buff.Bytes()
+		if bytes.Contains(bs, []byte(csrfPlaceholder)) {
+			bs = bytes.Replace(bs, []byte(csrfPlaceholder), []byte(template.HTMLEscapeString(this.CSRFToken())), -1)
+		}
+		if !hasHeader(this.ResHeaders, "Content-Type") {
+			cType := mime.TypeByExtension(path.Ext(fileName))
+			if len(cType) == 0 {
+				cType = "text/html; charset=utf-8"
+			}
+			this.ResponseHeader("Content-Type", cType)
+		}
+		this.ResponseOK()
+		this.ResponseData(bs)
+	}
+	if e != nil {
+		log.Error("Router handle tpl failed: req[%s] fn[%s] datas[%v] error[%v]", this.Request.URL.Path, fileName, datas, e)
+	}
+	return
+}
+
+func csrfInput(token string) template.HTML {
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(CSRFField) + `" value="` + token + `">`)
+}
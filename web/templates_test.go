@@ -0,0 +1,46 @@
+package web
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type urlPage struct {
+	Handler
+}
+
+func (this *urlPage) Handle() {
+	this.Tpl("page.html", nil)
+}
+
+func TestTemplatesURLPerServer(t *testing.T) {
+	dir := t.TempDir()
+	if e := os.WriteFile(filepath.Join(dir, "page.html"), []byte(`{{url "item" "id" 3}}`), 0644); e != nil {
+		t.Fatal(e)
+	}
+	shared := NewTemplates(dir)
+	a, b := &HttpServer{Templates: shared}, &HttpServer{Templates: shared}
+	a.AddRouter("/page", func() IHandler { return new(urlPage) })
+	a.AddRouter("/a/items/:id", func() IHandler { return new(urlPage) }, "item")
+	b.AddRouter("/page", func() IHandler { return new(urlPage) })
+	b.AddRouter("/b/items/:id", func() IHandler { return new(urlPage) }, "item")
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		for server, expect := range map[*HttpServer]string{a: "/a/items/3", b: "/b/items/3"} {
+			wg.Add(1)
+			go func(server *HttpServer, expect string) {
+				defer wg.Done()
+				rec := httptest.NewRecorder()
+				server.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/page", nil))
+				if rec.Code != 200 || rec.Body.String() != expect {
+					t.Errorf("expect %s, got %d %s", expect, rec.Code, rec.Body.String())
+				}
+			}(server, expect)
+		}
+	}
+	wg.Wait()
+}
@@ -0,0 +1,298 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"zwei.ren/log"
+)
+
+var (
+	// 检查证书文件变化的间隔，<1 时不自动重新加载
+	CertReloadInterval = time.Second * 10
+
+	Err_NoCertificate = errors.New("No certificate")
+	Err_InvalidCA     = errors.New("No valid certificate in CA file")
+)
+
+type certEntry struct {
+	certFile string
+	keyFile  string
+	stamp    time.Time
+	cert     *tls.Certificate
+}
+
+// 按 SNI 选择证书，文件变化后自动重新加载
+type certStore struct {
+	lock     sync.RWMutex
+	entries  []*certEntry
+	watchers int // 正在运行的 HTTPS 监听数，都停止后才停止检查
+	stop     chan struct{}
+}
+
+// 证书和私钥文件中较新的修改时间
+func certStamp(certFile, keyFile string) (stamp time.Time, e error) {
+	for _, file := range []string{certFile, keyFile} {
+		var info os.FileInfo
+		if info, e = os.Stat(file); e != nil {
+			return
+		}
+		if info.ModTime().After(stamp) {
+			stamp = info.ModTime()
+		}
+	}
+	return
+}
+
+func loadCert(certFile, keyFile string) (*certEntry, error) {
+	stamp, e := certStamp(certFile, keyFile)
+	if e != nil {
+		return nil, e
+	}
+	cert, e := tls.LoadX509KeyPair(certFile, keyFile)
+	if e != nil {
+		return nil, e
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, e = x509.ParseCertificate(cert.Certificate[0]); e != nil {
+			return nil, e
+		}
+	}
+	return &certEntry{certFile: certFile, keyFile: keyFile, stamp: stamp, cert: &cert}, nil
+}
+
+func (this *certStore) add(certFile, keyFile string) error {
+	entry, e := loadCert(certFile, keyFile)
+	if e != nil {
+		return e
+	}
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	for i, old := range this.entries {
+		if old.certFile == certFile && old.keyFile == keyFile {
+			this.entries[i] = entry
+			return nil
+		}
+	}
+	this.entries = append(this.entries, entry)
+	return nil
+}
+
+// tls.Config.GetCertificate，没有匹配的域名时使用第一个证书
+func (this *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	this.lock.RLock()
+	defer this.lock.RUnlock()
+	if len(this.entries) == 0 {
+		return nil, Err_NoCertificate
+	}
+	if name := strings.TrimSuffix(hello.ServerName, "."); len(name) != 0 {
+		for _, entry := range this.entries {
+			if entry.cert.Leaf.VerifyHostname(name) == nil {
+				return entry.cert, nil
+			}
+		}
+	}
+	return this.entries[0].cert, nil
+}
+
+func (this *certStore) reload() {
+	this.lock.RLock()
+	entries := append([]*certEntry{}, this.entries...)
+	this.lock.RUnlock()
+	for _, entry := range entries {
+		if stamp, e := certStamp(entry.certFile, entry.keyFile); e != nil || stamp.Equal(entry.stamp) {
+			continue
+		}
+		// 证书和私钥可能不是同时写入的，加载失败时保留旧的，下次再试
+		if e := this.add(entry.certFile, entry.keyFile); e == nil {
+			log.Info("Certificate %s reloaded", entry.certFile)
+		} else {
+			log.Error("Reload certificate %s failed: %v", entry.certFile, e)
+		}
+	}
+}
+
+func (this *certStore) watch() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.watchers++
+	if this.stop != nil || CertReloadInterval < 1 {
+		return
+	}
+	this.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(CertReloadInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				this.reload()
+			case <-stop:
+				return
+			}
+		}
+	}(this.stop)
+}
+
+func (this *certStore) close() {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	if this.watchers--; this.watchers == 0 && this.stop != nil {
+		close(this.stop)
+		this.stop = nil
+	}
+}
+
+// 添加证书，客户端按 SNI 选择，可以在运行中添加
+func AddCertificate(certFile, keyFile string) error {
+	return DefaultServer.AddCertificate(certFile, keyFile)
+}
+
+func (this *HttpServer) AddCertificate(certFile, keyFile string) error {
+	return this.certs.add(certFile, keyFile)
+}
+
+// 双向认证，客户端证书需要由 caFile 中的证书签发，required 为 false 时允许不提供证书
+func SetClientCA(caFile string, required bool) error {
+	return DefaultServer.SetClientCA(caFile, required)
+}
+
+func (this *HttpServer) SetClientCA(caFile string, required bool) error {
+	bs, e := ioutil.ReadFile(caFile)
+	if e != nil {
+		return e
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(bs) {
+		return Err_InvalidCA
+	}
+	// 之后启动的 HTTPS 监听生效
+	this.serverLock.Lock()
+	defer this.serverLock.Unlock()
+	this.clientCAs = pool
+	if required {
+		this.clientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		this.clientAuth = tls.VerifyClientCertIfGiven
+	}
+	return nil
+}
+
+// 在 prepare 中持有 serverLock 时调用
+func (this *HttpServer) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: this.certs.getCertificate,
+		ClientCAs:      this.clientCAs,
+		ClientAuth:     this.clientAuth,
+	}
+}
+
+// 以 HTTPS 运行并启用 HTTP/2，certFile 为空时只使用 AddCertificate 添加的证书，
+// 证书文件变化后自动重新加载，可以多次调用同时监听多个地址，addr 的格式见 Listen
+func RunTLS(addr, certFile, keyFile string) error {
+	return DefaultServer.RunTLS(addr, certFile, keyFile)
+}
+
+func (this *HttpServer) RunTLS(addr, certFile, keyFile string) error {
+	return this.RunTLSWithTimeout(addr, certFile, keyFile, ReadTimeout, WriteTimeout)
+}
+
+func (this *HttpServer) RunTLSWithTimeout(addr, certFile, keyFile string, readTimeout, writeTimeout time.Duration) error {
+	if len(certFile) != 0 {
+		if e := this.certs.add(certFile, keyFile); e != nil {
+			return e
+		}
+	}
+	listener, e := Listen(addr)
+	if e != nil {
+		return e
+	}
+	server, e := this.prepare(listener.Addr().String(), readTimeout, writeTimeout, true)
+	if e != nil {
+		listener.Close()
+		return e
+	}
+	this.certs.watch()
+	defer this.certs.close()
+	return this.logRun("address", listener.Addr().String(), func() error {
+		return server.ServeTLS(listener, "", "")
+	})
+}
+
+// 双向认证时客户端的证书，没有或者未通过验证时返回nil
+func (this *Handler) PeerCertificate() *x509.Certificate {
+	if state := this.Request.TLS; state != nil && len(state.VerifiedChains) != 0 && len(state.VerifiedChains[0]) != 0 {
+		return state.VerifiedChains[0][0]
+	}
+	return nil
+}
+
+// 监听 addr，把所有 HTTP 请求重定向到 HTTPS，httpsPort 为 443 或 <1 时省略端口，
+// 随服务器一起平滑关闭
+func RunRedirect(addr string, httpsPort int) error {
+	return DefaultServer.RunRedirect(addr, httpsPort)
+}
+
+func (this *HttpServer) RunRedirect(addr string, httpsPort int) error {
+	server := &http.Server{
+		Addr:              addr,
+		ReadTimeout:       ReadTimeout,
+		WriteTimeout:      WriteTimeout,
+		ReadHeaderTimeout: ReadTimeout,
+		Handler:           http.HandlerFunc(this.redirectHandler(httpsPort)),
+	}
+	// 和主服务一起在 Shutdown() 中关闭
+	this.drain.lock.Lock()
+	if this.drain.redirects == nil {
+		this.drain.redirects = map[*http.Server]struct{}{}
+	}
+	this.drain.redirects[server] = struct{}{}
+	this.drain.lock.Unlock()
+	defer func() {
+		this.drain.lock.Lock()
+		delete(this.drain.redirects, server)
+		this.drain.lock.Unlock()
+	}()
+	return this.logRun("redirect address", addr, server.ListenAndServe)
+}
+
+// 经过可信代理时跳转到代理转发的 Host
+func (this *HttpServer) redirectHandler(httpsPort int) func(w http.ResponseWriter, request *http.Request) {
+	return func(w http.ResponseWriter, request *http.Request) {
+		host := request.Host
+		if _, hop := this.resolveClient(request); len(hop.host) != 0 {
+			host = hop.host
+		}
+		if h, _, e := net.SplitHostPort(host); e == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+		if len(host) == 0 {
+			http.Error(w, "Missing Host", http.StatusBadRequest)
+			return
+		}
+		if httpsPort > 0 && httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		// 非 GET/HEAD 用 308 保证客户端不改变请求方法
+		code := http.StatusMovedPermanently
+		if request.Method != http.MethodGet && request.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, request, "https://"+host+request.URL.RequestURI(), code)
+	}
+}
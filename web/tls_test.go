@@ -0,0 +1,119 @@
+package web
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 自签名证书，写入 dir 下的 cert.pem 和 key.pem
+func writeCert(t *testing.T, dir, name string) (certFile, keyFile string) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+	}
+	der, e := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if e != nil {
+		t.Fatal(e)
+	}
+	kb, _ := x509.MarshalECPrivateKey(key)
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0644)
+	return
+}
+
+func freeAddr(t *testing.T) string {
+	listener, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+// 服务器证书的 CommonName，连接失败时返回空
+func peerName(addr string) string {
+	conn, e := tls.DialWithDialer(&net.Dialer{Timeout: time.Second}, "tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if e != nil {
+		return ""
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Subject.CommonName
+}
+
+func waitPeerName(addr, expect string) string {
+	name := ""
+	for i := 0; i < 50 && name != expect; i++ {
+		time.Sleep(20 * time.Millisecond)
+		name = peerName(addr)
+	}
+	return name
+}
+
+func TestRunTLSListeners(t *testing.T) {
+	interval := CertReloadInterval
+	CertReloadInterval = 20 * time.Millisecond
+	defer func() { CertReloadInterval = interval }()
+
+	dir := t.TempDir()
+	certFile, keyFile := writeCert(t, dir, "a.test")
+	server := &HttpServer{}
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	done := make(chan error, len(addrs))
+	for _, addr := range addrs {
+		go func(addr string) {
+			done <- server.RunTLSWithTimeout(addr, certFile, keyFile, time.Second, time.Second)
+		}(addr)
+	}
+	// 每个地址都在监听
+	for _, addr := range addrs {
+		if name := waitPeerName(addr, "a.test"); name != "a.test" {
+			t.Fatalf("%s: %q", addr, name)
+		}
+	}
+
+	// 同一地址再次运行失败，不影响其它监听的证书重新加载
+	if e := server.RunTLS(addrs[0], certFile, keyFile); e == nil {
+		t.Fatal("expect address in use")
+	}
+	writeCert(t, dir, "b.test")
+	// 保证修改时间变化，触发重新加载
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	os.Chtimes(keyFile, later, later)
+	for _, addr := range addrs {
+		if name := waitPeerName(addr, "b.test"); name != "b.test" {
+			t.Errorf("%s: not reloaded, %q", addr, name)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if e := server.Shutdown(ctx); e != nil {
+		t.Fatal(e)
+	}
+	for range addrs {
+		<-done
+	}
+	server.certs.lock.RLock()
+	defer server.certs.lock.RUnlock()
+	if server.certs.watchers != 0 || server.certs.stop != nil {
+		t.Error("certificate watcher not stopped", server.certs.watchers)
+	}
+}
@@ -0,0 +1,109 @@
+package web
+
+import (
+	"strings"
+)
+
+// 路由树，按 "/" 切分后每一段作为一个节点
+// 静态段 > 参数段(:name) > 通配段(*name)，都匹配不上时退回到最近的一个有路由的祖先节点(前缀匹配)
+type routeNode struct {
+	children  map[string]*routeNode
+	param     *routeNode
+	catchAll  *routeNode
+	paramName string
+	router    *_Router
+}
+
+func splitRoutePath(p string) []string {
+	p = strings.TrimPrefix(p, "/")
+	p = strings.TrimSuffix(p, "/")
+	if len(p) == 0 {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// 解析路由的参数名，同时检查格式
+func parseRoutePattern(pattern string) (segs, paramNames []string) {
+	segs = splitRoutePath(pattern)
+	names := map[string]bool{}
+	for i, seg := range segs {
+		if len(seg) == 0 {
+			continue
+		}
+		switch seg[0] {
+		case ':', '*':
+			name := seg[1:]
+			if len(name) == 0 {
+				panic("Router [" + pattern + "] wrong: empty parameter name")
+			}
+			if names[name] {
+				panic("Router [" + pattern + "] wrong: duplicate parameter '" + name + "'")
+			}
+			if seg[0] == '*' && i != len(segs)-1 {
+				panic("Router [" + pattern + "] wrong: '" + seg + "' must be the last segment")
+			}
+			names[name] = true
+			paramNames = append(paramNames, name)
+		}
+	}
+	return
+}
+
+// 找到或者创建 pattern 对应的节点
+func (this *routeNode) insert(pattern string, segs []string) *routeNode {
+	node := this
+	for _, seg := range segs {
+		var next *routeNode
+		switch {
+		case len(seg) != 0 && seg[0] == ':':
+			if next = node.param; next == nil {
+				next = &routeNode{paramName: seg[1:]}
+				node.param = next
+			} else if next.paramName != seg[1:] {
+				panic("Router [" + pattern + "] conflicts: ':" + seg[1:] + "' with existing ':" + next.paramName + "'")
+			}
+		case len(seg) != 0 && seg[0] == '*':
+			if next = node.catchAll; next == nil {
+				next = &routeNode{paramName: seg[1:]}
+				node.catchAll = next
+			} else if next.paramName != seg[1:] {
+				panic("Router [" + pattern + "] conflicts: '*" + seg[1:] + "' with existing '*" + next.paramName + "'")
+			}
+		default:
+			if node.children == nil {
+				node.children = map[string]*routeNode{}
+			}
+			if next = node.children[seg]; next == nil {
+				next = &routeNode{}
+				node.children[seg] = next
+			}
+		}
+		node = next
+	}
+	return node
+}
+
+// 返回匹配到的路由以及按顺序捕获的参数值
+func (this *routeNode) find(segs []string, values []string) (*_Router, []string) {
+	if len(segs) == 0 {
+		if this.router == nil && this.catchAll != nil && this.catchAll.router != nil {
+			return this.catchAll.router, append(values, "")
+		}
+		return this.router, values
+	}
+	if child, exists := this.children[segs[0]]; exists {
+		if r, vs := child.find(segs[1:], values); r != nil {
+			return r, vs
+		}
+	}
+	if this.param != nil && len(segs[0]) != 0 {
+		if r, vs := this.param.find(segs[1:], append(values, segs[0])); r != nil {
+			return r, vs
+		}
+	}
+	if this.catchAll != nil && this.catchAll.router != nil {
+		return this.catchAll.router, append(values, strings.Join(segs, "/"))
+	}
+	return this.router, values
+}
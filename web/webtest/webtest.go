@@ -0,0 +1,272 @@
+// 不监听端口测试 handler：请求直接交给 HttpServer 的路由、中间件和 handler 处理
+//
+//	client := webtest.New(server)
+//	res := client.Post("/user").JSON(map[string]interface{}{"name": "a"}).Do()
+//	if res.Status() != 200 { ... }
+//	var user User
+//	res.JSON(&user)
+package webtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+
+	"zwei.ren/web"
+)
+
+// 保存响应中的 cookie，后续请求自动带上，可以测试 session、csrf 等
+type Client struct {
+	Server *web.HttpServer
+	// 每个请求都会带上的请求头
+	Header http.Header
+
+	lock    sync.Mutex
+	cookies map[string]*http.Cookie
+}
+
+// server 为nil时使用 web.DefaultServer
+func New(server *web.HttpServer) *Client {
+	if server == nil {
+		server = web.DefaultServer
+	}
+	return &Client{Server: server, Header: http.Header{}, cookies: map[string]*http.Cookie{}}
+}
+
+func (this *Client) Get(target string) *Request {
+	return this.NewRequest(http.MethodGet, target)
+}
+func (this *Client) Post(target string) *Request {
+	return this.NewRequest(http.MethodPost, target)
+}
+func (this *Client) Put(target string) *Request {
+	return this.NewRequest(http.MethodPut, target)
+}
+func (this *Client) Patch(target string) *Request {
+	return this.NewRequest(http.MethodPatch, target)
+}
+func (this *Client) Delete(target string) *Request {
+	return this.NewRequest(http.MethodDelete, target)
+}
+
+func (this *Client) NewRequest(method, target string) *Request {
+	return &Request{client: this, Method: method, Target: target, Header: http.Header{}}
+}
+
+// 执行任意请求，不经过 Request 构造
+func (this *Client) Do(request *http.Request) *Response {
+	for k, vs := range this.Header {
+		if len(request.Header[k]) == 0 {
+			request.Header[k] = vs
+		}
+	}
+	this.lock.Lock()
+	for _, cookie := range this.cookies {
+		if _, e := request.Cookie(cookie.Name); e != nil {
+			request.AddCookie(cookie)
+		}
+	}
+	this.lock.Unlock()
+
+	recorder := httptest.NewRecorder()
+	this.Server.Handler().ServeHTTP(recorder, request)
+	res := &Response{ResponseRecorder: recorder}
+
+	this.lock.Lock()
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(this.cookies, cookie.Name)
+		} else {
+			this.cookies[cookie.Name] = cookie
+		}
+	}
+	this.lock.Unlock()
+	return res
+}
+
+// 当前保存的 cookie
+func (this *Client) Cookie(name string) *http.Cookie {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	return this.cookies[name]
+}
+
+func (this *Client) SetCookie(cookie *http.Cookie) {
+	this.lock.Lock()
+	defer this.lock.Unlock()
+	this.cookies[cookie.Name] = cookie
+}
+
+type file struct {
+	field, name string
+	content     []byte
+}
+
+// 链式构造请求，Body 可以是 JSON、表单或 multipart 之一
+type Request struct {
+	client *Client
+	Method string
+	Target string
+	Header http.Header
+
+	body        io.Reader
+	contentType string
+	query       url.Values
+	form        url.Values
+	files       []file
+	cookies     []*http.Cookie
+	err         error
+}
+
+func (this *Request) SetHeader(key, value string) *Request {
+	this.Header.Set(key, value)
+	return this
+}
+
+func (this *Request) Cookie(cookie *http.Cookie) *Request {
+	this.cookies = append(this.cookies, cookie)
+	return this
+}
+
+// 追加 url 参数
+func (this *Request) Query(key, value string) *Request {
+	if this.query == nil {
+		this.query = url.Values{}
+	}
+	this.query.Add(key, value)
+	return this
+}
+
+func (this *Request) Body(contentType string, body io.Reader) *Request {
+	this.contentType, this.body = contentType, body
+	return this
+}
+
+func (this *Request) JSON(v interface{}) *Request {
+	bs, e := json.Marshal(v)
+	if e != nil {
+		this.err = e
+	}
+	return this.Body("application/json", bytes.NewReader(bs))
+}
+
+// 表单字段，有文件时按 multipart 提交，否则按 application/x-www-form-urlencoded
+func (this *Request) Field(key, value string) *Request {
+	if this.form == nil {
+		this.form = url.Values{}
+	}
+	this.form.Add(key, value)
+	return this
+}
+
+func (this *Request) Form(values url.Values) *Request {
+	for k, vs := range values {
+		for _, v := range vs {
+			this.Field(k, v)
+		}
+	}
+	return this
+}
+
+func (this *Request) File(field, fileName string, content []byte) *Request {
+	this.files = append(this.files, file{field: field, name: fileName, content: content})
+	return this
+}
+
+func (this *Request) build() (*http.Request, error) {
+	if this.err != nil {
+		return nil, this.err
+	}
+	target := this.Target
+	if len(this.query) != 0 {
+		if strings.Contains(target, "?") {
+			target += "&" + this.query.Encode()
+		} else {
+			target += "?" + this.query.Encode()
+		}
+	}
+	body, contentType := this.body, this.contentType
+	if len(this.files) != 0 {
+		buff := &bytes.Buffer{}
+		writer := multipart.NewWriter(buff)
+		for k, vs := range this.form {
+			for _, v := range vs {
+				writer.WriteField(k, v)
+			}
+		}
+		for _, f := range this.files {
+			part, e := writer.CreateFormFile(f.field, f.name)
+			if e != nil {
+				return nil, e
+			}
+			part.Write(f.content)
+		}
+		if e := writer.Close(); e != nil {
+			return nil, e
+		}
+		body, contentType = buff, writer.FormDataContentType()
+	} else if this.form != nil {
+		body, contentType = strings.NewReader(this.form.Encode()), "application/x-www-form-urlencoded"
+	}
+
+	request := httptest.NewRequest(this.Method, target, body)
+	for k, vs := range this.Header {
+		request.Header[k] = vs
+	}
+	if len(contentType) != 0 && len(request.Header.Get("Content-Type")) == 0 {
+		request.Header.Set("Content-Type", contentType)
+	}
+	for _, cookie := range this.cookies {
+		request.AddCookie(cookie)
+	}
+	return request, nil
+}
+
+// 请求构造失败(如 JSON 编码失败)时 panic，测试中直接失败
+func (this *Request) Do() *Response {
+	request, e := this.build()
+	if e != nil {
+		panic(e)
+	}
+	return this.client.Do(request)
+}
+
+// 记录下来的响应
+type Response struct {
+	*httptest.ResponseRecorder
+}
+
+func (this *Response) Status() int {
+	return this.Code
+}
+
+func (this *Response) Header(key string) string {
+	return this.Result().Header.Get(key)
+}
+
+func (this *Response) Text() string {
+	return this.Body.String()
+}
+
+func (this *Response) Bytes() []byte {
+	return this.Body.Bytes()
+}
+
+func (this *Response) JSON(v interface{}) error {
+	return json.Unmarshal(this.Body.Bytes(), v)
+}
+
+func (this *Response) Cookie(name string) *http.Cookie {
+	for _, cookie := range this.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
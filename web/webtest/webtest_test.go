@@ -0,0 +1,156 @@
+package webtest
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"zwei.ren/web"
+)
+
+type userHandler struct {
+	web.Handler
+}
+
+func (this *userHandler) Get() {
+	this.ResponseOK()
+	this.ResponseData(map[string]string{"id": this.PathParam("id"), "tab": this.GetGetParam("tab")})
+}
+
+type uploadHandler struct {
+	web.Handler
+}
+
+func (this *uploadHandler) Post() {
+	var form struct {
+		Name string                 `form:"name" validate:"required"`
+		File *web.MultipartFileData `form:"file"`
+	}
+	if e := this.Bind(&form); e != nil {
+		this.ResponseStatus(http.StatusBadRequest)
+		this.ResponseData(e.Error())
+		return
+	}
+	content, _ := form.File.Bytes()
+	http.SetCookie(this.Writer, &http.Cookie{Name: "uploaded", Value: form.File.FileName, Path: "/"})
+	this.ResponseOK()
+	this.ResponseData(form.Name + ":" + string(content))
+}
+
+type cookieHandler struct {
+	web.Handler
+}
+
+func (this *cookieHandler) Get() {
+	this.ResponseOK()
+	if cookie, e := this.Request.Cookie("uploaded"); e == nil {
+		this.ResponseData(cookie.Value)
+	} else {
+		this.ResponseData("none")
+	}
+}
+
+type orderHandler struct {
+	web.Handler
+}
+
+func (this *orderHandler) Handle() {
+	this.ResponseOK()
+	this.ResponseData(strings.Join(this.Request.Header["X-Order"], ","))
+}
+
+func trace(name string) web.Middleware {
+	return func(handler web.IHandler, next func()) {
+		request, _ := handler.GetIO()
+		request.Header.Add("X-Order", name)
+		next()
+		handler.ResponseHeader("X-After-"+name, "1")
+	}
+}
+
+func TestRouting(t *testing.T) {
+	server := &web.HttpServer{}
+	server.AddRouter("/users/:id", func() web.IHandler { return new(userHandler) })
+	client := New(server)
+
+	var user map[string]string
+	res := client.Get("/users/7").Query("tab", "info").SetHeader("Accept", "application/json").Do()
+	if e := res.JSON(&user); e != nil || res.Status() != http.StatusOK || user["id"] != "7" || user["tab"] != "info" {
+		t.Fatal(res.Status(), res.Text(), e)
+	}
+	if res = client.Post("/users/7").Do(); res.Status() != http.StatusMethodNotAllowed || !strings.Contains(res.Header("Allow"), "GET") {
+		t.Fatal(res.Status(), res.Header("Allow"))
+	}
+	if res = client.Get("/nothing").Do(); res.Status() != http.StatusNotFound {
+		t.Fatal(res.Status())
+	}
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	server := &web.HttpServer{}
+	server.Use(trace("global"))
+	api := server.Group("/api", trace("api"))
+	api.Group("/v1", trace("v1")).AddRouter("/order", func() web.IHandler { return new(orderHandler) })
+	server.AddRouter("/order", func() web.IHandler { return new(orderHandler) })
+	client := New(server)
+
+	res := client.Get("/api/v1/order").Do()
+	if res.Text() != "global,api,v1" {
+		t.Fatal(res.Text())
+	}
+	for _, name := range []string{"global", "api", "v1"} {
+		if res.Header("X-After-"+name) != "1" {
+			t.Fatal("missing header after", name)
+		}
+	}
+	if res = client.Get("/order").Do(); res.Text() != "global" {
+		t.Fatal(res.Text())
+	}
+}
+
+func TestUploadAndCookies(t *testing.T) {
+	server := &web.HttpServer{}
+	server.AddRouter("/upload", func() web.IHandler { return new(uploadHandler) })
+	server.AddRouter("/cookie", func() web.IHandler { return new(cookieHandler) })
+	client := New(server)
+
+	if res := client.Post("/upload").Field("name", "").File("file", "a.txt", []byte("hi")).Do(); res.Status() != http.StatusBadRequest {
+		t.Fatal(res.Status(), res.Text())
+	}
+	if res := client.Get("/cookie").Do(); res.Text() != "none" {
+		t.Fatal(res.Text())
+	}
+	res := client.Post("/upload").Field("name", "bob").File("file", "a.txt", []byte("hi")).Do()
+	if res.Status() != http.StatusOK || res.Text() != "bob:hi" || res.Cookie("uploaded") == nil {
+		t.Fatal(res.Status(), res.Text())
+	}
+	if res = client.Get("/cookie").Do(); res.Text() != "a.txt" {
+		t.Fatal(res.Text())
+	}
+}
+
+func TestRange(t *testing.T) {
+	dir := t.TempDir()
+	if e := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("0123456789"), 0644); e != nil {
+		t.Fatal(e)
+	}
+	server := &web.HttpServer{}
+	server.AddStaticRouter("/static", dir)
+	client := New(server)
+
+	res := client.Get("/static/a.txt").SetHeader("Range", "bytes=2-5").Do()
+	if res.Status() != http.StatusPartialContent || res.Text() != "2345" || res.Header("Content-Range") != "bytes 2-5/10" {
+		t.Fatal(res.Status(), res.Text(), res.Header("Content-Range"))
+	}
+	if res = client.Get("/static/a.txt").SetHeader("Range", "bytes=-3").Do(); res.Text() != "789" {
+		t.Fatal(res.Status(), res.Text())
+	}
+	if res = client.Get("/static/a.txt").SetHeader("Range", "bytes=20-").Do(); res.Status() != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatal(res.Status())
+	}
+	if res = client.Get("/static/a.txt").Do(); res.Status() != http.StatusOK || res.Text() != "0123456789" {
+		t.Fatal(res.Status(), res.Text())
+	}
+}
@@ -0,0 +1,105 @@
+package web
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// 包装 http.ResponseWriter，记录真正写出的状态码和字节数给日志用
+type responseWriter struct {
+	http.ResponseWriter
+	status   int
+	size     int64
+	hijacked bool
+	server   *HttpServer
+	tracked  *hijackedConn
+	metrics  *metrics // 请求开始时的统计，没有启用时为nil
+	detached bool     // 已经变成长连接(websocket、SSE)
+}
+
+func (this *responseWriter) WriteHeader(code int) {
+	if this.status == 0 {
+		this.status = code
+	}
+	this.ResponseWriter.WriteHeader(code)
+}
+
+func (this *responseWriter) Write(bs []byte) (n int, e error) {
+	if this.status == 0 {
+		this.status = http.StatusOK
+	}
+	n, e = this.ResponseWriter.Write(bs)
+	this.size += int64(n)
+	return
+}
+
+func (this *responseWriter) Flush() {
+	if flusher, is := this.ResponseWriter.(http.Flusher); is {
+		flusher.Flush()
+	}
+}
+
+// websocket 需要
+func (this *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, is := this.ResponseWriter.(http.Hijacker); is {
+		conn, rw, e := hijacker.Hijack()
+		if e == nil {
+			this.hijacked = true
+			this.detach()
+			if this.server != nil {
+				this.tracked = this.server.trackConn(conn)
+			}
+		}
+		return conn, rw, e
+	}
+	return nil, nil, errors.New("Hijack not supported")
+}
+
+// 变成长连接，从请求的统计中移到长连接的统计
+func (this *responseWriter) detach() {
+	if !this.detached {
+		this.detached = true
+		if this.metrics != nil {
+			this.metrics.detach()
+		}
+	}
+}
+
+// 从中间件包装的 writer 中找到 responseWriter，没有时返回nil
+func unwrapWriter(w http.ResponseWriter) *responseWriter {
+	for {
+		switch writer := w.(type) {
+		case *responseWriter:
+			return writer
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = writer.Unwrap()
+		default:
+			return nil
+		}
+	}
+}
+
+// 请求结束，不再跟踪 hijacked 连接
+func (this *responseWriter) release() {
+	if this.tracked != nil {
+		this.server.untrackConn(this.tracked)
+		this.tracked = nil
+	}
+}
+
+func (this *responseWriter) Unwrap() http.ResponseWriter {
+	return this.ResponseWriter
+}
+
+// 实际的状态码，还没写出时返回 defaultStatus
+func (this *responseWriter) Status(defaultStatus int) int {
+	if this.hijacked && this.status == 0 {
+		return http.StatusSwitchingProtocols
+	}
+	if this.status == 0 {
+		return defaultStatus
+	}
+	return this.status
+}